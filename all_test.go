@@ -27,3 +27,13 @@ func makeTestGroup2048() *cyclic.Group {
 		large.NewInt(2),
 	)
 }
+
+// 3072 bits doesn't fill up the 3200 bit environment, like a real MODP3072
+// prime wouldn't
+func makeTestGroup3072() *cyclic.Group {
+	p := large.NewIntFromString("C522BD67414A27FDD215286C5D17954CF29D8D8B894344A111B2799EB6356C73127DA8DE63A05F803393AEDA6EF54B9CD796A55E9D3E6EE4919E74FCA71C759B5DA8403B32A68F2F12EB12AFCACDF42547F8C0FAF77B636132D68CAFFFC641EBAA247F1F1599E4C2E0005B6CE31FEDA48137814655B1466CCA1B73AEB6B6A0FC33521CDBE4B81C2843361E22261FBDB8B27FAC7E6FF35206AF572F14D0DF2D7B0BAF1247A3B3E91505DB9476C615EFC4A4E540BDFDDC84E96FC9A76B23FC6AB143EAFD9A31E3EE1F62540D60DEAC91B86C8EA6B5141D81878E0A25D86BB2C18668057B9FB09E80F0F6280BF753ECB0F6AFCC4B17978EB43443710D8B677776CE116D26659DBA1F73435EB2C4A59AF95BB21BFEA15F20A8C76364543EB43352041E8A50EA651EBE95FB9CF34F5284C4C8E5089B99A1E00B8B83CFC70BCA43341815E476C4871B27DAF6544A798B49EF8195F198A3DB51732921C69066C61E5D352E3209C317A9B523D3B120E3E49318CB35E546B38DF30AD638A26632AA5F8409", 16)
+	return cyclic.NewGroup(
+		p,
+		large.NewInt(2),
+	)
+}
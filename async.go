@@ -0,0 +1,50 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+// async.go contains the handle that the Async chunk functions return. The
+// chunk runs in the background on streams from the pool, which go back to
+// the pool as soon as it's done, whether or not anything waits on it.
+
+// ChunkHandle is a chunk operation running in the background
+type ChunkHandle struct {
+	done chan struct{}
+	err  error
+}
+
+// goChunk runs a chunk operation in the background
+func goChunk(op func() error) *ChunkHandle {
+	h := &ChunkHandle{done: make(chan struct{})}
+	go func() {
+		h.err = op()
+		close(h.done)
+	}()
+	return h
+}
+
+// Done returns a channel that's closed once the chunk is done
+func (h *ChunkHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Err returns the chunk's error once it's done, and nil while it's running
+func (h *ChunkHandle) Err() error {
+	select {
+	case <-h.done:
+		return h.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the chunk is done and returns its error. The chunk's
+// outputs can't be used until it's done.
+func (h *ChunkHandle) Wait() error {
+	<-h.done
+	return h.err
+}
@@ -0,0 +1,121 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
+	"testing"
+	"time"
+)
+
+// Exp and ElGamal launched together should both finish with the right
+// results, and give their streams back
+func TestChunkAsync_ExpAndElGamal(t *testing.T) {
+	g := makeTestGroup2048()
+	pool, err := NewStreamPool(2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const numSlots = 8
+	x := initRandomIntBuffer(g, numSlots, 1, 0)
+	y := initRandomIntBuffer(g, numSlots, 2, 32)
+	z := g.NewIntBuffer(numSlots, g.NewInt(1))
+	key := initRandomIntBuffer(g, numSlots, 3, 0)
+	privateKey := initRandomIntBuffer(g, numSlots, 4, 32)
+	publicCypherKey := initRandomIntBuffer(g, 1, 5, 0).Get(0)
+	ecrKey := initRandomIntBuffer(g, numSlots, 6, 0)
+	cypher := initRandomIntBuffer(g, numSlots, 7, 0)
+	expectedEcrKey := ecrKey.DeepCopy()
+	expectedCypher := cypher.DeepCopy()
+
+	ctx := context.Background()
+	expHandle := ExpChunkAsync(ctx, pool, g, x, y, z)
+	elGamalHandle := ElGamalChunkAsync(ctx, pool, g, key, privateKey, publicCypherKey, ecrKey, cypher)
+	if err = expHandle.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err = elGamalHandle.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := g.NewIntBuffer(numSlots, g.NewInt(1))
+	for i := uint32(0); i < numSlots; i++ {
+		cryptops.Exp(g, x.Get(i), y.Get(i), expected.Get(i))
+		cryptops.ElGamal(g, key.Get(i), privateKey.Get(i), publicCypherKey,
+			expectedEcrKey.Get(i), expectedCypher.Get(i))
+	}
+	checkSlots(t, expected, z)
+	checkSlots(t, expectedEcrKey, ecrKey)
+	checkSlots(t, expectedCypher, cypher)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		_, err = pool.TakeStreamContext(ctx)
+		if err != nil {
+			t.Fatal("both streams should be back in the pool")
+		}
+	}
+}
+
+// A handle isn't done, and has no error, until the chunk has finished
+func TestChunkHandle_Done(t *testing.T) {
+	g := makeTestGroup2048()
+	pool, err := NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := initRandomIntBuffer(g, 4, 1, 0)
+	y := initRandomIntBuffer(g, 4, 2, 0)
+	result := y.DeepCopy()
+
+	// The chunk can't start while the only stream is checked out
+	s := pool.TakeStream()
+	h := Mul2ChunkAsync(context.Background(), pool, g, x, y, result)
+	select {
+	case <-h.Done():
+		t.Fatal("the chunk shouldn't be done without a stream")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if h.Err() != nil {
+		t.Errorf("a running chunk shouldn't have an error, got %v", h.Err())
+	}
+
+	pool.ReturnStream(s)
+	select {
+	case <-h.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the chunk should be done once it gets a stream")
+	}
+	if h.Err() != nil || h.Wait() != nil {
+		t.Error(h.Err())
+	}
+}
+
+// Cancelling the context stops a chunk that's waiting for a stream
+func TestChunkHandle_Cancel(t *testing.T) {
+	g := makeTestGroup2048()
+	pool, err := NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := initRandomIntBuffer(g, 4, 1, 0)
+	s := pool.TakeStream()
+	defer pool.ReturnStream(s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := RevealChunkAsync(ctx, pool, g, x.Get(0), x, x.DeepCopy())
+	cancel()
+	if err = h.Wait(); err != context.Canceled {
+		t.Errorf("expected the chunk to be cancelled, got %v", err)
+	}
+	if h.Err() != context.Canceled {
+		t.Errorf("Err should return the chunk's error once it's done, got %v", h.Err())
+	}
+}
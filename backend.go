@@ -0,0 +1,140 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"gitlab.com/elixxir/crypto/cyclic"
+	"sort"
+	"sync"
+)
+
+// backend.go holds the registry of compute backends. The exported chunk
+// functions (ExpChunk, ElGamalChunk, etc.) and NewStreamPool dispatch to
+// whichever backend is active, so the same binary can run its operations on
+// the GPU, on the CPU or on a test backend depending on its configuration.
+
+// NoGpuErrStr is the message of ErrNoGPU, which is returned when the gpu is
+// not supported in the build.
+//
+// Deprecated: check for ErrNoGPU with errors.Is instead.
+const NoGpuErrStr = "gpumaths stubbed build doesn't support CUDA stream pool"
+
+// Names of the backends provided by this package
+const (
+	// Always registered
+	CPUBackendName = "cpu"
+	// Only registered when built with CUDA (-tags gpu on linux)
+	GPUBackendName = "gpu"
+)
+
+// Backend implements every chunk operation, as well as creation of the
+// stream pools that they run on.
+// Each method has the same contract as the exported function of the same
+// name with Context on the end, and the exported Slice functions run on the
+// same methods. Implementations must be safe for concurrent use.
+type Backend interface {
+	// Name is the name the backend is registered and selected under
+	Name() string
+	NewStreamPool(numStreams int, memSize int) (*StreamPool, error)
+	// ExpChunk returns z
+	ExpChunk(ctx context.Context, p *StreamPool, g *cyclic.Group,
+		x, y, z Ints) (Ints, error)
+	ElGamalChunk(ctx context.Context, p *StreamPool, g *cyclic.Group,
+		key, privateKey Ints, publicCypherKey *cyclic.Int,
+		ecrKey, cypher Ints) error
+	RevealChunk(ctx context.Context, p *StreamPool, g *cyclic.Group,
+		publicCypherKey *cyclic.Int, cypher, result Ints) error
+	Mul2Chunk(ctx context.Context, p *StreamPool, g *cyclic.Group,
+		x, y, results Ints) error
+	Mul3Chunk(ctx context.Context, p *StreamPool, g *cyclic.Group,
+		x, y, z, results Ints) error
+}
+
+// If no backend has been selected, the first of these that's registered
+// becomes active
+var defaultBackends = []string{GPUBackendName, CPUBackendName}
+
+var backends = struct {
+	sync.RWMutex
+	registered map[string]Backend
+	active     Backend
+}{registered: make(map[string]Backend)}
+
+// RegisterBackend makes a backend available to SetBackend under its name.
+// Registering two backends with the same name is an error.
+func RegisterBackend(b Backend) error {
+	backends.Lock()
+	defer backends.Unlock()
+	if _, ok := backends.registered[b.Name()]; ok {
+		return errors.Errorf("a gpumaths backend named %q is already registered", b.Name())
+	}
+	backends.registered[b.Name()] = b
+	return nil
+}
+
+// SetBackend selects the registered backend that all subsequent chunk
+// operations and stream pool creation go through
+func SetBackend(name string) error {
+	backends.Lock()
+	defer backends.Unlock()
+	b, ok := backends.registered[name]
+	if !ok {
+		if name == GPUBackendName {
+			return ErrNoGPU
+		}
+		return errors.Errorf("no gpumaths backend named %q is registered", name)
+	}
+	backends.active = b
+	return nil
+}
+
+// GetBackend returns the registered backend with the given name, if any
+func GetBackend(name string) (Backend, bool) {
+	backends.RLock()
+	defer backends.RUnlock()
+	b, ok := backends.registered[name]
+	return b, ok
+}
+
+// ActiveBackend returns the backend that operations currently run on.
+// Until SetBackend is called, that's the GPU backend if this build supports
+// CUDA, and the CPU backend otherwise.
+func ActiveBackend() Backend {
+	backends.RLock()
+	active := backends.active
+	backends.RUnlock()
+	if active != nil {
+		return active
+	}
+
+	backends.Lock()
+	defer backends.Unlock()
+	if backends.active == nil {
+		for _, name := range defaultBackends {
+			if b, ok := backends.registered[name]; ok {
+				backends.active = b
+				break
+			}
+		}
+	}
+	return backends.active
+}
+
+// BackendNames lists the names of all registered backends in sorted order
+func BackendNames() []string {
+	backends.RLock()
+	defer backends.RUnlock()
+	names := make([]string, 0, len(backends.registered))
+	for name := range backends.registered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,172 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"gitlab.com/elixxir/crypto/cyclic"
+	"sync"
+	"testing"
+)
+
+const testBackendName = "test"
+
+// testBackend computes everything on the CPU, but counts the calls it gets
+type testBackend struct {
+	cpuBackend
+	mux   sync.Mutex
+	calls map[string]int
+}
+
+var testBackendInstance = &testBackend{calls: make(map[string]int)}
+
+func init() {
+	err := RegisterBackend(testBackendInstance)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (*testBackend) Name() string {
+	return testBackendName
+}
+
+func (b *testBackend) record(op string) {
+	b.mux.Lock()
+	b.calls[op]++
+	b.mux.Unlock()
+}
+
+func (b *testBackend) numCalls(op string) int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.calls[op]
+}
+
+func (b *testBackend) NewStreamPool(numStreams int, memSize int) (*StreamPool, error) {
+	b.record("NewStreamPool")
+	return b.cpuBackend.NewStreamPool(numStreams, memSize)
+}
+
+func (b *testBackend) ExpChunk(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x, y, z Ints) (Ints, error) {
+	b.record("ExpChunk")
+	return b.cpuBackend.ExpChunk(ctx, p, g, x, y, z)
+}
+
+func (b *testBackend) Mul2Chunk(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x, y, results Ints) error {
+	b.record("Mul2Chunk")
+	return b.cpuBackend.Mul2Chunk(ctx, p, g, x, y, results)
+}
+
+// useBackend makes the named backend active until the returned function is
+// called, which restores the previously active backend
+func useBackend(t *testing.T, name string) func() {
+	backends.Lock()
+	previous := backends.active
+	backends.Unlock()
+	err := SetBackend(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		backends.Lock()
+		backends.active = previous
+		backends.Unlock()
+	}
+}
+
+// The exported functions should go through whichever backend is selected
+func TestSetBackend_Dispatch(t *testing.T) {
+	defer useBackend(t, testBackendName)()
+	if ActiveBackend().Name() != testBackendName {
+		t.Fatalf("active backend should be %q, not %q", testBackendName, ActiveBackend().Name())
+	}
+
+	streamPool, err := NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := makeTestGroup2048()
+	x := initRandomIntBuffer(g, 3, 42, 0)
+	_, err = ExpChunk(streamPool, g, x, x, g.NewIntBuffer(3, g.NewInt(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Mul2Chunk(streamPool, g, x, x, g.NewIntBuffer(3, g.NewInt(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, op := range []string{"NewStreamPool", "ExpChunk", "Mul2Chunk"} {
+		if testBackendInstance.numCalls(op) == 0 {
+			t.Errorf("%v wasn't dispatched to the test backend", op)
+		}
+	}
+}
+
+// Selecting a backend that doesn't exist should fail and leave the active
+// backend alone
+func TestSetBackend_Unknown(t *testing.T) {
+	before := ActiveBackend()
+	err := SetBackend("abacus")
+	if err == nil {
+		t.Error("selecting an unregistered backend should fail")
+	}
+	if ActiveBackend() != before {
+		t.Error("a failed SetBackend shouldn't change the active backend")
+	}
+}
+
+// Without CUDA, selecting the GPU backend should explain why it's missing
+func TestSetBackend_NoGPU(t *testing.T) {
+	if _, ok := GetBackend(GPUBackendName); ok {
+		t.Skip("this build has the GPU backend")
+	}
+	err := SetBackend(GPUBackendName)
+	checkErrorIs(t, "SetBackend", err, ErrNoGPU)
+}
+
+// Until a backend is selected, the GPU backend should be used if it's been
+// compiled in, and the CPU backend otherwise
+func TestActiveBackend_Default(t *testing.T) {
+	backends.Lock()
+	previous := backends.active
+	backends.active = nil
+	backends.Unlock()
+	defer func() {
+		backends.Lock()
+		backends.active = previous
+		backends.Unlock()
+	}()
+
+	expected := CPUBackendName
+	if _, ok := GetBackend(GPUBackendName); ok {
+		expected = GPUBackendName
+	}
+	if ActiveBackend().Name() != expected {
+		t.Errorf("default backend should be %q, not %q", expected, ActiveBackend().Name())
+	}
+}
+
+// Two backends can't share a name
+func TestRegisterBackend_Duplicate(t *testing.T) {
+	err := RegisterBackend(cpuBackend{})
+	if err == nil {
+		t.Error("registering a second backend named cpu should fail")
+	}
+	names := BackendNames()
+	found := false
+	for _, name := range names {
+		found = found || name == CPUBackendName
+	}
+	if !found {
+		t.Errorf("the cpu backend should be registered, but only %v are", names)
+	}
+}
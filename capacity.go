@@ -0,0 +1,184 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"github.com/pkg/errors"
+)
+
+// capacity.go contains the layouts of the native library's stream buffers,
+// written out in Go, so callers can plan how much memory their streams need
+// in any build, without linking the native library.
+
+// kernelLayout is how many numbers a kernel's constants, and each slot's
+// inputs and outputs, take up in a stream's buffer
+type kernelLayout struct {
+	constants int
+	inputs    int
+	outputs   int
+}
+
+// kernelLayouts mirror the constants, input and output structs that the
+// native library lays out for each kernel
+var kernelLayouts = [numKernels]kernelLayout{
+	// constants: prime
+	// inputs: x, y
+	// outputs: x**y
+	kernelPowmOdd: {constants: 1, inputs: 2, outputs: 1},
+	// constants: g, prime, publicCypherKey
+	// inputs: privateKey, key, ecrKey, cypher
+	// outputs: ecrKey, cypher
+	kernelElgamal: {constants: 3, inputs: 4, outputs: 2},
+	// constants: prime, publicCypherKey
+	// inputs: cypher
+	// outputs: cypher**(1/publicCypherKey)
+	kernelReveal: {constants: 2, inputs: 1, outputs: 1},
+	// constants: prime
+	// inputs: x, y
+	// outputs: x*y
+	kernelMul2: {constants: 1, inputs: 2, outputs: 1},
+	// constants: prime
+	// inputs: x, y, z
+	// outputs: x*y*z
+	kernelMul3: {constants: 1, inputs: 3, outputs: 1},
+}
+
+// layoutWidth is a row in an env registry for numbers of bitLen bits, with
+// sizes that come from kernelLayouts. It can size streams, but not run
+// kernels.
+func layoutWidth(bitLen int) envWidth {
+	return envWidth{
+		bitLen: bitLen,
+		sizes: func(k kernel) (int, int, int) {
+			layout := kernelLayouts[k]
+			byteLen := bitLen / 8
+			return layout.constants * byteLen, layout.inputs * byteLen, layout.outputs * byteLen
+		},
+	}
+}
+
+// nativeBitLens are the widths that the native library is built for. Both
+// nativeLayouts and the CUDA device's envs are made from them.
+var nativeBitLens = []int{2048, 3200, 4096}
+
+// nativeWidths makes a row with width for each of nativeBitLens
+func nativeWidths(width func(bitLen int) envWidth) []envWidth {
+	widths := make([]envWidth, len(nativeBitLens))
+	for i, bitLen := range nativeBitLens {
+		widths[i] = width(bitLen)
+	}
+	return widths
+}
+
+// nativeLayouts are the native library's widths, with sizes that come from
+// kernelLayouts
+var nativeLayouts = newEnvRegistry(nativeWidths(layoutWidth)...)
+
+// OpLayout is how much of a stream's buffer an op takes up
+type OpLayout struct {
+	// Width in bits of the numbers the op's kernel runs on. Primes are padded
+	// out to the narrowest width that fits them.
+	BitLen int
+	// Bytes taken up once, by the op's constants
+	ConstantsSize int
+	// Bytes taken up by each slot's inputs
+	InputSize int
+	// Bytes taken up by each slot's outputs
+	OutputSize int
+}
+
+// GetOpLayout returns how op lays out a stream's buffer for primes of bitLen
+// bits, i.e. g.GetP().BitLen(). It returns ErrPrimeTooLarge if the native
+// library has no kernels wide enough for the prime.
+func GetOpLayout(bitLen int, op Op) (OpLayout, error) {
+	k, err := op.kernel()
+	if err != nil {
+		return OpLayout{}, err
+	}
+	env, err := nativeLayouts.choose(bitLen)
+	if err == nil {
+		err = env.checkKernel(k)
+	}
+	if err != nil {
+		return OpLayout{}, err
+	}
+	return OpLayout{
+		BitLen:        env.getBitLen(),
+		ConstantsSize: env.getConstantsSize(k),
+		InputSize:     env.getInputSize(k),
+		OutputSize:    env.getOutputSize(k),
+	}, nil
+}
+
+// MaxSlotsFor returns how many slots of op fit in a stream with memSize
+// bytes of memory, for primes of bitLen bits
+func MaxSlotsFor(bitLen int, op Op, memSize int) (int, error) {
+	layout, err := GetOpLayout(bitLen, op)
+	if err != nil {
+		return 0, err
+	}
+	memForSlots := memSize - layout.ConstantsSize
+	if memForSlots < 0 {
+		return 0, nil
+	}
+	return memForSlots / (layout.InputSize + layout.OutputSize), nil
+}
+
+// StreamMemSize returns how many bytes of memory a stream needs to hold
+// numSlots slots of op, for primes of bitLen bits
+func StreamMemSize(bitLen int, op Op, numSlots int) (int, error) {
+	if numSlots < 0 {
+		return 0, errors.Errorf("can't hold %v slots", numSlots)
+	}
+	layout, err := GetOpLayout(bitLen, op)
+	if err != nil {
+		return 0, err
+	}
+	return layout.ConstantsSize + numSlots*(layout.InputSize+layout.OutputSize), nil
+}
+
+// The sizing helpers below don't take the prime's width, so they assume
+// primes as wide as the native library's widest kernels. That's never more
+// slots than fit.
+
+// widestBitLen is the width of the native library's widest kernels
+func widestBitLen() int {
+	bitLens := nativeLayouts.bitLens()
+	return bitLens[len(bitLens)-1]
+}
+
+// MaxSlots returns how many slots of op fit in a stream with memSize bytes
+// of memory, for primes of the widest width. It returns 0 for ops that don't
+// exist.
+//
+// Deprecated: use MaxSlotsFor, which takes the prime's width.
+func MaxSlots(memSize int, op int) int {
+	slots, err := MaxSlotsFor(widestBitLen(), Op(op), memSize)
+	if err != nil {
+		return 0
+	}
+	return slots
+}
+
+// GetMaxSlotsExp returns how many slots of ExpChunk fit in the stream, for
+// primes of the widest width. Streams from the CPU backend have no memory,
+// so they fit none.
+//
+// Deprecated: use MaxSlotsFor, which takes the prime's width.
+func (s *Stream) GetMaxSlotsExp() int {
+	return MaxSlots(len(s.cpuData), int(OpExp))
+}
+
+// GetMaxSlotsElGamal returns how many slots of ElGamalChunk fit in the
+// stream, for primes of the widest width. Streams from the CPU backend have
+// no memory, so they fit none.
+//
+// Deprecated: use MaxSlotsFor, which takes the prime's width.
+func (s *Stream) GetMaxSlotsElGamal() int {
+	return MaxSlots(len(s.cpuData), int(OpElGamal))
+}
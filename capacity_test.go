@@ -0,0 +1,126 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"github.com/pkg/errors"
+	"testing"
+)
+
+// Primes should be padded out to the native library's widths
+func TestGetOpLayout(t *testing.T) {
+	for _, c := range []struct {
+		bitLen int
+		op     Op
+		layout OpLayout
+	}{
+		{2048, OpExp, OpLayout{BitLen: 2048, ConstantsSize: 256, InputSize: 512, OutputSize: 256}},
+		{2000, OpMul2, OpLayout{BitLen: 2048, ConstantsSize: 256, InputSize: 512, OutputSize: 256}},
+		{3072, OpElGamal, OpLayout{BitLen: 3200, ConstantsSize: 1200, InputSize: 1600, OutputSize: 800}},
+		{4096, OpReveal, OpLayout{BitLen: 4096, ConstantsSize: 1024, InputSize: 512, OutputSize: 512}},
+		{4096, OpMul3, OpLayout{BitLen: 4096, ConstantsSize: 512, InputSize: 1536, OutputSize: 512}},
+	} {
+		layout, err := GetOpLayout(c.bitLen, c.op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if layout != c.layout {
+			t.Errorf("%v bit %v: expected %+v, got %+v", c.bitLen, c.op, c.layout, layout)
+		}
+	}
+
+	_, err := GetOpLayout(4097, OpExp)
+	if !errors.Is(err, ErrPrimeTooLarge) {
+		t.Errorf("expected ErrPrimeTooLarge, got %v", err)
+	}
+	_, err = GetOpLayout(2048, numOps)
+	if !errors.Is(err, ErrUnknownKernel) {
+		t.Errorf("expected ErrUnknownKernel, got %v", err)
+	}
+}
+
+// A stream sized for some slots should hold exactly that many
+func TestMaxSlotsFor_StreamMemSize(t *testing.T) {
+	for _, bitLen := range []int{2048, 3072, 4096} {
+		for op := Op(0); op < numOps; op++ {
+			for _, numSlots := range []int{0, 1, 37} {
+				memSize, err := StreamMemSize(bitLen, op, numSlots)
+				if err != nil {
+					t.Fatal(err)
+				}
+				fits, err := MaxSlotsFor(bitLen, op, memSize)
+				if err != nil {
+					t.Fatal(err)
+				}
+				fitsSmaller, err := MaxSlotsFor(bitLen, op, memSize-1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fits != numSlots || (numSlots > 0 && fitsSmaller != numSlots-1) {
+					t.Errorf("%v bit %v: %v bytes should fit exactly %v slots, got %v and %v",
+						bitLen, op, memSize, numSlots, fits, fitsSmaller)
+				}
+			}
+		}
+	}
+
+	fits, err := MaxSlotsFor(2048, OpExp, 0)
+	if err != nil || fits != 0 {
+		t.Errorf("a stream without room for the constants should fit no slots, got %v, %v", fits, err)
+	}
+	_, err = StreamMemSize(2048, OpExp, -1)
+	if err == nil {
+		t.Error("a negative number of slots should be an error")
+	}
+}
+
+// Capacity planning should agree with the emulated device, which lays out
+// streams in the same way as the native library
+func TestMaxSlotsFor_MatchesEmulated(t *testing.T) {
+	const memSize = 1 << 20
+	for _, bitLen := range nativeLayouts.bitLens() {
+		env := mustChooseEnv(t, emulatedDevice{}, bitLen)
+		for op := Op(0); op < numOps; op++ {
+			fits, err := MaxSlotsFor(bitLen, op, memSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fits != env.maxSlots(memSize, opKernels[op]) {
+				t.Errorf("%v bit %v: MaxSlotsFor was %v, but the emulated env fits %v",
+					bitLen, op, fits, env.maxSlots(memSize, opKernels[op]))
+			}
+		}
+	}
+}
+
+// The deprecated helpers should size for the widest primes
+func TestMaxSlots_Deprecated(t *testing.T) {
+	const memSize = 1 << 20
+	for op := Op(0); op < numOps; op++ {
+		fits, err := MaxSlotsFor(4096, op, memSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if MaxSlots(memSize, int(op)) != fits {
+			t.Errorf("%v: MaxSlots was %v, expected %v", op, MaxSlots(memSize, int(op)), fits)
+		}
+	}
+	if MaxSlots(memSize, int(numOps)) != 0 {
+		t.Error("an op that doesn't exist should fit no slots")
+	}
+
+	s := Stream{cpuData: make([]byte, memSize)}
+	if s.GetMaxSlotsExp() != MaxSlots(memSize, int(OpExp)) ||
+		s.GetMaxSlotsElGamal() != MaxSlots(memSize, int(OpElGamal)) {
+		t.Errorf("a stream's helpers should fit as many slots as MaxSlots, got %v and %v",
+			s.GetMaxSlotsExp(), s.GetMaxSlotsElGamal())
+	}
+	if (&Stream{}).GetMaxSlotsExp() != 0 {
+		t.Error("a stream without memory should fit no slots")
+	}
+}
@@ -0,0 +1,283 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	jww "github.com/spf13/jwalterweatherman"
+	"gitlab.com/elixxir/crypto/cyclic"
+	"sync/atomic"
+	"time"
+)
+
+// chunk_gpu.go contains the loop that every gpu chunk function uses to split
+// a chunk into kernels that fit in a stream.
+
+// gpuOp is a chunk function's op, ready for runChunk to split up
+type gpuOp struct {
+	// Name of the chunk function, for logging
+	name     string
+	kernel   kernel
+	numSlots uint32
+	// put lays out the slots in [start, end) in the stream's buffer
+	put func(env gpumathsEnv, stream Stream, start, end uint32)
+	// importResults copies the results of the slots in [start, end) out of
+	// the stream's buffer once the kernel has run
+	importResults func(env gpumathsEnv, stream Stream, start, end uint32)
+	// cpu computes the slots in [start, end) on the CPU instead, for when the
+	// kernel fails or the chunk is split with the CPU
+	cpu func(start, end uint32) error
+	// check saves the inputs of a slot before the chunk runs, and returns a
+	// function that recomputes the slot with cryptops afterwards and says
+	// whether it matches
+	check func(slot uint32) func() bool
+}
+
+// startKernel enqueues a kernel on slots that are already laid out in the
+// stream's buffer. The returned channel gets the kernel's error once its
+// results are back in the buffer.
+func startKernel(env gpumathsEnv, stream Stream, k kernel, numSlots int) chan error {
+	done := make(chan error, 1)
+	// Upload, run, download
+	err := env.enqueue(stream, k, numSlots)
+	if err != nil {
+		done <- err
+		return done
+	}
+	go func() {
+		// Wait on things to finish on the device
+		done <- env.get(stream)
+	}()
+	return done
+}
+
+// runChunk runs an op on streams from the pool, using as many kernels as it
+// takes to fit all the slots in the streams' buffers. If the pool's hybrid
+// policy is on, the CPU computes the end of the chunk at the same time, and
+// if its verify policy is on, some of the slots computed on the GPU are
+// checked afterwards.
+// Once ctx is done, runChunk stops between kernels and returns ctx.Err().
+// Using this function doesn't allow you to do other things while waiting
+// on the kernels to finish
+func (b gpuBackend) runChunk(ctx context.Context, p *StreamPool, g *cyclic.Group, op gpuOp) error {
+	env, err := b.dev.chooseEnv(g.GetP().BitLen())
+	if err == nil {
+		err = env.checkKernel(op.kernel)
+	}
+	if err != nil {
+		return errors.Wrap(err, op.name)
+	}
+	key := hybridKey{op: op.name, bitLen: env.getBitLen()}
+	cpuStart := p.hybridSplit(key, op.numSlots)
+	// Save the inputs of the slots to check before anything overwrites them
+	checks := saveChecks(op, p.verifySample(cpuStart))
+	if cpuStart == op.numSlots {
+		_, _, err := runKernels(ctx, p, env, op, 0, op.numSlots)
+		if err != nil {
+			return err
+		}
+		return runChecks(op, checks)
+	}
+
+	var cpuTime time.Duration
+	cpuErr := make(chan error, 1)
+	go func() {
+		start := time.Now()
+		err := op.cpu(cpuStart, op.numSlots)
+		cpuTime = time.Since(start)
+		cpuErr <- err
+	}()
+	gpuTime, fellBack, err := runKernels(ctx, p, env, op, 0, cpuStart)
+	// Wait for the CPU even if the GPU failed, so no slots are still being
+	// written once this returns
+	cpuErrVal := <-cpuErr
+	if err != nil {
+		return err
+	}
+	if cpuErrVal != nil {
+		return cpuErrVal
+	}
+	// Kernels that fell back didn't run at the GPU's speed
+	if !fellBack {
+		p.recordHybridTimings(key, cpuStart, gpuTime, op.numSlots-cpuStart, cpuTime)
+	}
+	return runChecks(op, checks)
+}
+
+// runKernels runs the slots in [start, end) on streams from the pool, with
+// as many slots in each kernel as fit in a stream. If that takes more than
+// one kernel, whichever other streams are free get taken to run them in
+// parallel, and if the pool's pipeline policy is on, the streams are paired
+// up to overlap each kernel with the host's work on the next one. If only one
+// stream could be taken, it runs full kernels as if pipelining were off. The
+// first kernel that fails without falling back stops the rest.
+// It returns how long the kernels took once the first stream was taken, and
+// whether any of them fell back to the CPU.
+func runKernels(ctx context.Context, p *StreamPool, env gpumathsEnv, op gpuOp,
+	start, end uint32) (time.Duration, bool, error) {
+	first, err := takeGPUStream(ctx, p)
+	if err != nil {
+		return 0, false, err
+	}
+	began := time.Now()
+	maxSlots := uint32(env.maxSlots(len(first.cpuData), op.kernel))
+	if maxSlots == 0 {
+		p.ReturnStream(first)
+		return 0, false, errors.Errorf("%v: streams are too small for a single slot", op.name)
+	}
+	streamSlots := maxSlots
+	pipelined := p.GetPipelinePolicy().Enabled
+	if pipelined {
+		maxSlots = p.pipelineBatchSlots(end-start, maxSlots)
+	}
+	numKernels := (end - start + maxSlots - 1) / maxSlots
+	streams := []Stream{first}
+	for uint32(len(streams)) < numKernels {
+		stream, ok := tryTakeGPUStream(p)
+		if !ok {
+			break
+		}
+		streams = append(streams, stream)
+	}
+	// Pipelining takes a pair of streams. Small batches on one stream would
+	// only add kernels, so fill the stream instead.
+	if pipelined && len(streams) < 2 {
+		pipelined = false
+		maxSlots = streamSlots
+		numKernels = (end - start + maxSlots - 1) / maxSlots
+	}
+	if numKernels > uint32(len(streams)) && !pipelined {
+		p.recordSplit()
+		jww.WARN.Printf("Running %v kernels for %v on %v streams. Performance may be degraded",
+			numKernels, op.name, len(streams))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	k := kernelQueue{start: start, end: end, maxSlots: maxSlots}
+	errs := make(chan error, len(streams))
+	numWorkers := 0
+	for i := 0; i < len(streams); i++ {
+		numWorkers++
+		if pipelined && i+1 < len(streams) {
+			go func(pair [2]Stream) {
+				errs <- runPipelined(ctx, p, env, op, pair, &k)
+			}([2]Stream{streams[i], streams[i+1]})
+			i++
+		} else {
+			go func(stream Stream) {
+				errs <- runKernelsOn(ctx, p, env, op, stream, &k)
+			}(streams[i])
+		}
+	}
+	var firstErr error
+	for i := 0; i < numWorkers; i++ {
+		err := <-errs
+		if err != nil && firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	if firstErr != nil {
+		return 0, k.fellBack(), firstErr
+	}
+	return time.Since(began), k.fellBack(), nil
+}
+
+// kernelQueue hands out the kernels of a chunk to the streams running them
+type kernelQueue struct {
+	start, end, maxSlots uint32
+	// Index of the next kernel to run
+	next uint32
+	// Set if any kernel fell back to the CPU
+	anyFellBack int32
+}
+
+// claim returns the slots of the next kernel to run, or false if there are
+// none left
+func (k *kernelQueue) claim() (uint32, uint32, bool) {
+	i := atomic.AddUint32(&k.next, 1) - 1
+	if i >= (k.end-k.start+k.maxSlots-1)/k.maxSlots {
+		return 0, 0, false
+	}
+	sliceStart := k.start + i*k.maxSlots
+	sliceEnd := sliceStart + k.maxSlots
+	// Don't slice beyond the end of the input slice
+	if sliceEnd > k.end {
+		sliceEnd = k.end
+	}
+	return sliceStart, sliceEnd, true
+}
+
+func (k *kernelQueue) fellBack() bool {
+	return atomic.LoadInt32(&k.anyFellBack) != 0
+}
+
+// kernelFailed applies the pool's fallback policy to a kernel that failed.
+// It returns the kernel's error if the policy doesn't fall back, or else
+// the error from computing the kernel's slots on the CPU.
+func (k *kernelQueue) kernelFailed(p *StreamPool, op gpuOp, stream Stream,
+	start, end uint32, err error) error {
+	// Outputs are only written once a kernel succeeds, so the slots' inputs
+	// are still intact for the CPU to use
+	if !p.kernelFailed(stream, Fallback{Op: op.name, StreamID: stream.id,
+		Start: start, End: end, Err: err}) {
+		return err
+	}
+	atomic.StoreInt32(&k.anyFellBack, 1)
+	return op.cpu(start, end)
+}
+
+// returnWhenDone gives a stream back to the pool once the kernel running on
+// it is done. A stream can't be reused while a kernel is still running on it.
+func returnWhenDone(p *StreamPool, stream Stream, kernelDone chan error) {
+	go func() {
+		<-kernelDone
+		p.ReturnStream(stream)
+	}()
+}
+
+// runKernelsOn runs kernels from the queue on one stream until there are
+// none left or ctx is done, then gives the stream back
+func runKernelsOn(ctx context.Context, p *StreamPool, env gpumathsEnv, op gpuOp,
+	stream Stream, k *kernelQueue) error {
+	for {
+		if ctx.Err() != nil {
+			p.ReturnStream(stream)
+			return ctx.Err()
+		}
+		sliceStart, sliceEnd, ok := k.claim()
+		if !ok {
+			p.ReturnStream(stream)
+			return nil
+		}
+		op.put(env, stream, sliceStart, sliceEnd)
+		p.recordLaunch(op.name, sliceEnd-sliceStart)
+		kernelDone := startKernel(env, stream, op.kernel, int(sliceEnd-sliceStart))
+		var err error
+		select {
+		case err = <-kernelDone:
+		case <-ctx.Done():
+			// The kernel's results are abandoned, so the caller's outputs
+			// aren't touched once this returns
+			returnWhenDone(p, stream, kernelDone)
+			return ctx.Err()
+		}
+		if err == nil {
+			p.kernelSucceeded(stream)
+			op.importResults(env, stream, sliceStart, sliceEnd)
+		} else {
+			err = k.kernelFailed(p, op, stream, sliceStart, sliceEnd, err)
+			if err != nil {
+				p.ReturnStream(stream)
+				return err
+			}
+		}
+	}
+}
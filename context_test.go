@@ -0,0 +1,151 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// blockingDevice is an emulated device whose kernels don't finish until
+// they're released
+type blockingDevice struct {
+	emulatedDevice
+	// Receives once a kernel has started
+	started chan struct{}
+	// Each kernel waits for a receive before it finishes
+	release chan struct{}
+}
+
+type blockingEnv struct {
+	*tableEnv
+	d blockingDevice
+}
+
+func (d blockingDevice) chooseEnv(bitLen int) (gpumathsEnv, error) {
+	env, err := d.emulatedDevice.chooseEnv(bitLen)
+	if err != nil {
+		return nil, err
+	}
+	return &blockingEnv{
+		tableEnv: env.(*tableEnv),
+		d:        d,
+	}, nil
+}
+
+func (e *blockingEnv) get(stream Stream) error {
+	e.d.started <- struct{}{}
+	<-e.d.release
+	return e.tableEnv.get(stream)
+}
+
+func TestStreamPool_TakeStreamContext(t *testing.T) {
+	pool, err := cpuBackend{}.NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := pool.TakeStreamContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The only stream is checked out, so this has to give up
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = pool.TakeStreamContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	// Giving up shouldn't have lost the stream
+	pool.ReturnStream(s)
+	s2, err := pool.TakeStreamContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s2.id != s.id {
+		t.Errorf("expected stream %v back, got %v", s.id, s2.id)
+	}
+}
+
+// The CPU backend doesn't start on a chunk whose context is already done
+func TestCPUBackend_ExpChunk_Cancelled(t *testing.T) {
+	g := makeTestGroup2048()
+	pool, err := cpuBackend{}.NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+	y := initRandomIntBuffer(g, emulatedNumSlots, 2, 32)
+	z := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = cpuBackend{}.ExpChunk(ctx, pool, g, x, y, z)
+	if err != context.Canceled {
+		t.Errorf("expected the chunk to be cancelled, got %v", err)
+	}
+	for i := uint32(0); i < emulatedNumSlots; i++ {
+		if z.Get(i).Cmp(g.NewInt(1)) != 0 {
+			t.Errorf("slot %v shouldn't have been computed", i)
+		}
+	}
+}
+
+// Cancelling a chunk while a kernel is running returns straight away, and the
+// kernel's results never get written to the outputs. The stream only goes
+// back to the pool once the kernel is done with it.
+func TestGPUBackend_Mul2Chunk_CancelMidKernel(t *testing.T) {
+	g := makeTestGroup2048()
+	d := blockingDevice{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	b := gpuBackend{dev: d}
+	env := mustChooseEnv(t, b.dev, g.GetP().BitLen())
+	pool, err := b.NewStreamPool(1, env.streamSizeContaining(emulatedSlotsPerStream, kernelMul2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+	y := initRandomIntBuffer(g, emulatedNumSlots, 2, 0)
+	result := y.DeepCopy()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	chunkErr := make(chan error, 1)
+	go func() {
+		chunkErr <- b.Mul2Chunk(ctx, pool, g, x, y, result)
+	}()
+	<-d.started
+	cancel()
+	select {
+	case err = <-chunkErr:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelling didn't stop the chunk")
+	}
+	if err != context.Canceled {
+		t.Errorf("expected the chunk to be cancelled, got %v", err)
+	}
+
+	// The stream is still in use by the kernel
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer waitCancel()
+	_, err = pool.TakeStreamContext(waitCtx)
+	if err != context.DeadlineExceeded {
+		t.Error("the stream shouldn't be back in the pool while its kernel runs")
+	}
+
+	d.release <- struct{}{}
+	s, err := pool.TakeStreamContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.ReturnStream(s)
+	checkSlots(t, y, result)
+}
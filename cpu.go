@@ -5,15 +5,90 @@
 // LICENSE file.                                                              //
 ////////////////////////////////////////////////////////////////////////////////
 
-//+build !linux !gpu
-
 package gpumaths
 
-// api_cpu.go (and all of the *_cpu.go files) hold stub information necessary
-// to make the api build without the importers having to do anything.
-// Instead of crashing/breaking the build, we return error messages back
-// on all the api calls in lieu of performing the operation in the cpu (this
-// is future work)
+import (
+	"context"
+	"github.com/pkg/errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// cpu.go (and all of the *_cpu.go files) hold the CPU backend, which is
+// available in every build and is the default when the api is built without
+// CUDA, so importers don't have to do anything.
+// Every operation is computed in pure Go, spread across all available cores,
+// and stream pools hand out CPU worker slots instead of CUDA streams.
+
+// cpuBackend runs the chunk operations on the CPU
+type cpuBackend struct{}
+
+func init() {
+	err := RegisterBackend(cpuBackend{})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Name returns the name of the CPU backend ("cpu")
+func (cpuBackend) Name() string {
+	return CPUBackendName
+}
+
+// NewStreamPool creates a pool of numStreams CPU worker slots
+// memSize isn't used, as there's no device memory
+func (cpuBackend) NewStreamPool(numStreams int, memSize int) (*StreamPool, error) {
+	if numStreams <= 0 {
+		return nil, errors.New("a stream pool needs at least one stream")
+	}
+	return newStreamPool(make([]Stream, numStreams), nil)
+}
+
+// forEachSlot calls fn once for every slot index in [0, numSlots). The slots
+// are handed out to a pool of worker goroutines sized to GOMAXPROCS, and
+// forEachSlot returns once every slot has been processed.
+// fn must be safe to call concurrently for different slots.
+func forEachSlot(numSlots uint32, fn func(i uint32)) {
+	_ = forEachSlotContext(context.Background(), numSlots, fn)
+}
+
+// forEachSlotContext is forEachSlot, but the workers stop claiming slots once
+// ctx is done. It returns ctx.Err() if any slots were skipped, once the slots
+// that had already been claimed are finished.
+func forEachSlotContext(ctx context.Context, numSlots uint32, fn func(i uint32)) error {
+	numWorkers := uint32(runtime.GOMAXPROCS(0))
+	if numWorkers > numSlots {
+		numWorkers = numSlots
+	}
 
-// NoGpuErrStr is the error returned when the gpu is not supported inthe build.
-const NoGpuErrStr = "gpumaths stubbed build doesn't support CUDA stream pool"
+	// Workers claim the next unprocessed slot until they run out, so a
+	// slow slot doesn't hold up a fixed share of the others
+	var next uint32
+	done := ctx.Done()
+	var wg sync.WaitGroup
+	wg.Add(int(numWorkers))
+	for w := uint32(0); w < numWorkers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				i := atomic.AddUint32(&next, 1) - 1
+				if i >= numSlots {
+					return
+				}
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+	// Workers that stopped after every slot was claimed didn't skip any
+	if atomic.LoadUint32(&next) < numSlots {
+		return ctx.Err()
+	}
+	return nil
+}
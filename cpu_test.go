@@ -0,0 +1,56 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+// Every slot should be visited exactly once, whatever the number of slots
+// relative to the number of workers
+func TestForEachSlot(t *testing.T) {
+	for _, numSlots := range []uint32{0, 1, 3, 64, 1000} {
+		visits := make([]uint32, numSlots)
+		forEachSlot(numSlots, func(i uint32) {
+			atomic.AddUint32(&visits[i], 1)
+		})
+		for i := range visits {
+			if visits[i] != 1 {
+				t.Errorf("slot %v of %v was visited %v times", i, numSlots, visits[i])
+			}
+		}
+	}
+}
+
+// Being cancelled after the last slot was claimed doesn't skip anything, so
+// it isn't an error
+func TestForEachSlotContext_CancelledAfterLastSlot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := forEachSlotContext(ctx, 1, func(i uint32) {
+		cancel()
+	})
+	if err != nil {
+		t.Errorf("every slot was computed, but got %v", err)
+	}
+}
+
+// Slots that are never claimed because of a cancellation make it an error
+func TestForEachSlotContext_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var visits uint32
+	err := forEachSlotContext(ctx, 64, func(i uint32) {
+		atomic.AddUint32(&visits, 1)
+	})
+	if err != context.Canceled || visits != 0 {
+		t.Errorf("expected no slots to run and context.Canceled, got %v slots and %v", visits, err)
+	}
+}
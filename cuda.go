@@ -0,0 +1,332 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+//+build linux,gpu
+
+package gpumaths
+
+// cuda.go contains the device that runs the gpu implementation's kernels
+// with CUDA, along with the envs that get the layout of each kernel's
+// buffers from the native library.
+
+// When the gpumaths library itself is under development, it should
+// use the version of gpumaths that's built in-repository
+// (./lib/libpowmosm75.so). golang puts a ./lib entry in the rpath
+// itself (as far as I can tell, but it's after everything, so
+// to have the ./lib version take priority, there's another entry
+// before so the development version takes precedence if both are
+// present
+
+// Devices are counted and selected with the CUDA runtime, which isn't in a
+// fixed place, so its include and library paths come from CGO_CFLAGS and
+// CGO_LDFLAGS, e.g.
+//  CGO_CFLAGS=-I/usr/local/cuda/include CGO_LDFLAGS=-L/usr/local/cuda/lib64
+
+/*
+#cgo CFLAGS: -I./cgbnBindings/powm -I/opt/xxnetwork/include
+#cgo LDFLAGS: -L/opt/xxnetwork/lib -lpowmosm75 -lcudart -Wl,-rpath,./lib:/opt/xxnetwork/lib
+#include <powm_odd_export.h>
+#include <cuda_runtime_api.h>
+#include <stdlib.h>
+#include <string.h>
+*/
+import "C"
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"gitlab.com/xx_network/crypto/large"
+	"reflect"
+	"runtime"
+	"unsafe"
+)
+
+// cudaDevice runs kernels on the CUDA device
+type cudaDevice struct{}
+
+func init() {
+	err := RegisterBackend(gpuBackend{dev: cudaDevice{}})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (cudaDevice) init() error {
+	return initCuda()
+}
+
+func (cudaDevice) numDevices() (int, error) {
+	var count C.int
+	err := cudaError(C.cudaGetDeviceCount(&count))
+	if err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+func (cudaDevice) createStreams(ordinal int, numStreams int, capacity int) ([]Stream, error) {
+	var streams []Stream
+	err := onDevice(ordinal, func() error {
+		var err error
+		streams, err = createStreams(numStreams, capacity)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range streams {
+		streams[i].device = ordinal
+	}
+	return streams, nil
+}
+
+// Streams are destroyed with their own device current
+func (cudaDevice) destroyStreams(streams []Stream) error {
+	for start := 0; start < len(streams); {
+		end := start + 1
+		for end < len(streams) && streams[end].device == streams[start].device {
+			end++
+		}
+		err := onDevice(streams[start].device, func() error {
+			return destroyStreams(streams[start:end])
+		})
+		if err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+// onDevice runs fn with the device current. CUDA keeps track of the current
+// device per thread, so the goroutine stays on its thread until fn returns.
+func onDevice(ordinal int, fn func() error) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	err := cudaError(C.cudaSetDevice(C.int(ordinal)))
+	if err != nil {
+		return errors.Wrapf(err, "couldn't switch to device %v", ordinal)
+	}
+	return fn()
+}
+
+// cudaError converts an error code from the CUDA runtime
+func cudaError(code C.cudaError_t) error {
+	if code == C.cudaSuccess {
+		return nil
+	}
+	return errors.New(C.GoString(C.cudaGetErrorString(code)))
+}
+
+func (cudaDevice) chooseEnv(bitLen int) (gpumathsEnv, error) {
+	env, err := cudaEnvs.choose(bitLen)
+	if err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// cKernels maps each kernel to its identifier in the native library
+var cKernels = [numKernels]C.enum_kernel{
+	kernelPowmOdd: C.KERNEL_POWM_ODD,
+	kernelElgamal: C.KERNEL_ELGAMAL,
+	kernelReveal:  C.KERNEL_REVEAL,
+	kernelMul2:    C.KERNEL_MUL2,
+	kernelMul3:    C.KERNEL_MUL3,
+}
+
+// cudaEnvs are the widths in nativeBitLens, run with the native library
+var cudaEnvs = newEnvRegistry(nativeWidths(cudaWidth)...)
+
+// cudaWidth is the row in cudaEnvs for numbers of bitLen bits. Adding a width
+// to nativeBitLens takes a case here with the native functions for it.
+func cudaWidth(bitLen int) envWidth {
+	switch bitLen {
+	case 2048:
+		return envWidth{
+			bitLen: 2048,
+			sizes: func(k kernel) (int, int, int) {
+				c := cKernels[k]
+				return int(C.getConstantsSize2048(c)), int(C.getInputSize2048(c)), int(C.getOutputSize2048(c))
+			},
+			enqueue: func(_ gpumathsEnv, stream Stream, k kernel, numSlots int) error {
+				return enqueue(stream, func() *C.char {
+					return C.enqueue2048(C.uint(numSlots), stream.s, cKernels[k])
+				})
+			},
+			get: get,
+		}
+	case 3200:
+		return envWidth{
+			bitLen: 3200,
+			sizes: func(k kernel) (int, int, int) {
+				c := cKernels[k]
+				return int(C.getConstantsSize3200(c)), int(C.getInputSize3200(c)), int(C.getOutputSize3200(c))
+			},
+			enqueue: func(_ gpumathsEnv, stream Stream, k kernel, numSlots int) error {
+				return enqueue(stream, func() *C.char {
+					return C.enqueue3200(C.uint(numSlots), stream.s, cKernels[k])
+				})
+			},
+			get: get,
+		}
+	case 4096:
+		return envWidth{
+			bitLen: 4096,
+			sizes: func(k kernel) (int, int, int) {
+				c := cKernels[k]
+				return int(C.getConstantsSize4096(c)), int(C.getInputSize4096(c)), int(C.getOutputSize4096(c))
+			},
+			enqueue: func(_ gpumathsEnv, stream Stream, k kernel, numSlots int) error {
+				return enqueue(stream, func() *C.char {
+					return C.enqueue4096(C.uint(numSlots), stream.s, cKernels[k])
+				})
+			},
+			get: get,
+		}
+	}
+	panic(fmt.Sprintf("the native library has no %v bit functions", bitLen))
+}
+
+// Create byte slice viewing memory at a certain memory address with a
+// certain length
+// Here be dragons
+func toSlice(pointer unsafe.Pointer, size int) []byte {
+	return *(*[]byte)(unsafe.Pointer(
+		&reflect.SliceHeader{Data: uintptr(pointer),
+			Len: size, Cap: size}))
+}
+
+func toSliceOfWords(pointer unsafe.Pointer, size int) large.Bits {
+	return *(*large.Bits)(unsafe.Pointer(
+		&reflect.SliceHeader{Data: uintptr(pointer),
+			Len: size, Cap: size}))
+}
+
+// Load the shared library and return any errors
+// Copies a C string into a Go error and frees the C string
+func goError(cString *C.char) error {
+	if cString != nil {
+		errorStringGo := C.GoString(cString)
+		err := errors.New(errorStringGo)
+		C.free((unsafe.Pointer)(cString))
+		return err
+	}
+	return nil
+}
+
+// Creates streams of a particular size meant to run a particular operation
+func createStreams(numStreams int, capacity int) ([]Stream, error) {
+	streamCreateInfo := C.struct_streamCreateInfo{
+		capacity: C.size_t(capacity),
+	}
+
+	streams := make([]Stream, 0, numStreams)
+
+	for i := 0; i < numStreams; i++ {
+		// We need to free this createStreamResult, right?
+		// Or, it might be possible to return the struct by value instead.
+		createStreamResult := C.createStream(streamCreateInfo)
+
+		// TODO Any possibility of double free here in error cases?
+		// Check for normally created error first, if it exists
+		if createStreamResult != nil && createStreamResult.error != nil {
+			createError := goError(createStreamResult.error)
+			// Attempt to clean up any streams that were successfully created
+			destroyErr := destroyStreams(append(streams, Stream{s: createStreamResult.result}))
+			C.free(unsafe.Pointer(createStreamResult))
+			if destroyErr != nil && createError != nil {
+				return nil, errors.Wrap(destroyErr, createError.Error())
+			} else if createError != nil {
+				return nil, createError
+			}
+		} else if createStreamResult != nil && C.isStreamValid(createStreamResult.result) == 0 {
+			// No error, but something in the stream wasn't set
+			// Attempt to clean up any streams that were successfully created
+			destroyErr := destroyStreams(append(streams, Stream{s: createStreamResult.result}))
+			C.free(unsafe.Pointer(createStreamResult))
+			if destroyErr != nil {
+				return nil, errors.Wrap(destroyErr, "not all fields of stream were initialized")
+			} else {
+				return nil, errors.New("not all fields of stream were initialized")
+			}
+		} else if createStreamResult == nil {
+			// Unlikely error, but one of the allocations for createStream return structures must have failed
+			// Attempt to clean up any streams that were successfully created
+			destroyError := destroyStreams(streams)
+			return nil, destroyError
+		}
+
+		// If we got here, we should have a good stream result from createStream
+		if createStreamResult.result != nil && createStreamResult.cpuBuf != nil {
+			sizeofOperand := make(large.Bits, 1)
+			streams = append(streams, Stream{
+				s:            createStreamResult.result,
+				cpuData:      toSlice(createStreamResult.cpuBuf, capacity),
+				cpuDataWords: toSliceOfWords(createStreamResult.cpuBuf, int(uintptr(capacity)/unsafe.Sizeof(sizeofOperand[0]))),
+			})
+		}
+		// Double free possible here?
+		C.free(unsafe.Pointer(createStreamResult))
+	}
+
+	return streams, nil
+}
+
+func destroyStreams(streams []Stream) error {
+	for i := 0; i < len(streams); i++ {
+		err := C.destroyStream(streams[i].s)
+		if err != nil {
+			return goError(err)
+		}
+	}
+	return nil
+}
+
+// Calculate x**y mod p using CUDA
+// Results are put in a byte array for translation back to cyclic ints elsewhere
+// Currently, we upload and execute all in the same method
+
+// Upload some items to the next stream
+// Returns the stream that the data were uploaded to
+// TODO Store the kernel enum for the upload in the stream
+//  That way you don't have to pass that info again for run
+//  There should be no scenario where the stream gets run for a different kernel than the upload
+// Could return byte slices of output as well? perhaps?
+// upload calls the native enqueue function for the env's width
+func enqueue(stream Stream, upload func() *C.char) error {
+	return onDevice(stream.device, func() error {
+		uploadError := upload()
+		if uploadError != nil {
+			return goError(uploadError)
+		}
+		return nil
+	})
+}
+
+// Block on stream's download and return any errors
+// This also checks the CGBN error report (presumably this is where things should be checked, if not now, then in the future, to see whether they're in the group or not. However this may not(?) be doable if everything is in Montgomery space.)
+func get(stream Stream) error {
+	return onDevice(stream.device, func() error {
+		cErr := C.getResults(stream.s)
+		return goError(cErr)
+	})
+}
+
+// Reset the CUDA device
+// Hopefully this will allow the CUDA profile to be gotten in the graphical profiler
+//func resetDevice() error {
+//	errString := C.resetDevice()
+//	err := goError(errString)
+//	return err
+//}
+
+func initCuda() error {
+	var err error
+	errString := C.initCuda()
+	err = goError(errString)
+	return err
+}
@@ -0,0 +1,129 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"github.com/pkg/errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// devices.go contains the selection of devices for stream pools that span
+// more than one GPU.
+
+// MultiDeviceBackend is implemented by backends that can create streams on
+// more than one device
+type MultiDeviceBackend interface {
+	Backend
+	// NumDevices returns how many devices streams can be created on
+	NumDevices() (int, error)
+	// NewStreamPoolOnDevices creates numStreams streams on each of the
+	// devices with the given ordinals
+	NewStreamPoolOnDevices(devices []int, numStreams int, memSize int) (*StreamPool, error)
+}
+
+// NewStreamPoolOnDevices creates a pool with numStreams streams on each of
+// the listed devices, using the active backend. Streams are handed out from
+// whichever device has the fewest checked out.
+// devices: Ordinals of the devices to use
+// numStreams: Number of streams per device. 2 is usually fine
+// memSize: Size in bytes of the memory each stream can use
+func NewStreamPoolOnDevices(devices []int, numStreams int, memSize int) (*StreamPool, error) {
+	b, err := activeMultiDeviceBackend()
+	if err != nil {
+		return nil, err
+	}
+	return b.NewStreamPoolOnDevices(devices, numStreams, memSize)
+}
+
+// NewStreamPoolOnAllDevices is NewStreamPoolOnDevices with every device the
+// active backend can find
+func NewStreamPoolOnAllDevices(numStreams int, memSize int) (*StreamPool, error) {
+	b, err := activeMultiDeviceBackend()
+	if err != nil {
+		return nil, err
+	}
+	devices, err := everyDevice(b)
+	if err != nil {
+		return nil, err
+	}
+	return b.NewStreamPoolOnDevices(devices, numStreams, memSize)
+}
+
+func activeMultiDeviceBackend() (MultiDeviceBackend, error) {
+	b, ok := ActiveBackend().(MultiDeviceBackend)
+	if !ok {
+		return nil, errors.Errorf("the %v backend can't create streams on more than one device",
+			ActiveBackend().Name())
+	}
+	return b, nil
+}
+
+// everyDevice returns the ordinals of every device the backend can find
+func everyDevice(b MultiDeviceBackend) ([]int, error) {
+	numDevices, err := b.NumDevices()
+	if err != nil {
+		return nil, err
+	}
+	if numDevices <= 0 {
+		return nil, errors.New("no devices were found")
+	}
+	devices := make([]int, numDevices)
+	for i := range devices {
+		devices[i] = i
+	}
+	return devices, nil
+}
+
+// ParseDevices parses a list of device ordinals separated by commas, such as
+// "0,2", or "all" for every device the active backend can find
+func ParseDevices(spec string) ([]int, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "all" {
+		b, err := activeMultiDeviceBackend()
+		if err != nil {
+			return nil, err
+		}
+		return everyDevice(b)
+	}
+	var devices []int
+	for _, field := range strings.Split(spec, ",") {
+		ordinal, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, errors.Errorf("%q isn't a device ordinal", field)
+		}
+		devices = append(devices, ordinal)
+	}
+	return devices, nil
+}
+
+// checkDevices makes sure that every ordinal is one of numDevices devices,
+// and that none are repeated
+func checkDevices(devices []int, numDevices int) error {
+	if numDevices <= 0 {
+		return errors.New("no devices were found")
+	}
+	if len(devices) == 0 {
+		return errors.New("no devices were selected")
+	}
+	sorted := append([]int(nil), devices...)
+	sort.Ints(sorted)
+	for i, ordinal := range sorted {
+		if ordinal < 0 || ordinal >= numDevices {
+			return errors.Errorf("device %v doesn't exist, there are %v devices",
+				ordinal, numDevices)
+		}
+		if i > 0 && sorted[i-1] == ordinal {
+			return errors.Errorf("device %v was selected more than once", ordinal)
+		}
+	}
+	return nil
+}
+
+var _ MultiDeviceBackend = gpuBackend{}
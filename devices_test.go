@@ -0,0 +1,164 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestParseDevices(t *testing.T) {
+	devices, err := ParseDevices(" 0, 2")
+	if err != nil || !reflect.DeepEqual(devices, []int{0, 2}) {
+		t.Errorf("expected [0 2], got %v, %v", devices, err)
+	}
+	_, err = ParseDevices("0,gpu1")
+	if err == nil {
+		t.Error("a list with something other than ordinals in it should be an error")
+	}
+}
+
+// "all" should only parse when the active backend can count its devices
+func TestParseDevices_All(t *testing.T) {
+	devices, err := ParseDevices("all")
+	b, ok := ActiveBackend().(MultiDeviceBackend)
+	if !ok {
+		if err == nil {
+			t.Errorf("the %v backend has no devices, but \"all\" parsed to %v",
+				ActiveBackend().Name(), devices)
+		}
+		return
+	}
+	expected, expectedErr := everyDevice(b)
+	if !reflect.DeepEqual(devices, expected) || (err == nil) != (expectedErr == nil) {
+		t.Errorf("\"all\" should parse to every device, %v, got %v, %v", expected, devices, err)
+	}
+}
+
+func TestEveryDevice(t *testing.T) {
+	devices, err := everyDevice(gpuBackend{dev: emulatedDevice{devices: 3}})
+	if err != nil || !reflect.DeepEqual(devices, []int{0, 1, 2}) {
+		t.Errorf("expected [0 1 2], got %v, %v", devices, err)
+	}
+}
+
+func TestCheckDevices(t *testing.T) {
+	err := checkDevices([]int{2, 0}, 3)
+	if err != nil {
+		t.Error(err)
+	}
+	for _, bad := range [][]int{nil, {}, {3}, {-1}, {1, 0, 1}} {
+		err = checkDevices(bad, 3)
+		if err == nil {
+			t.Errorf("selecting %v of 3 devices should be an error", bad)
+		}
+	}
+	err = checkDevices([]int{0}, 0)
+	if err == nil {
+		t.Error("there should be an error when there are no devices")
+	}
+}
+
+// Streams should be created on each selected device, and only those
+func TestGPUBackend_NewStreamPoolOnDevices(t *testing.T) {
+	b := gpuBackend{dev: emulatedDevice{devices: 4}}
+	pool, err := b.NewStreamPoolOnDevices([]int{3, 1}, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numOnDevice := make(map[int]int)
+	for _, s := range pool.streams {
+		numOnDevice[s.device]++
+	}
+	if !reflect.DeepEqual(numOnDevice, map[int]int{1: 2, 3: 2}) {
+		t.Errorf("expected two streams on devices 1 and 3, got %v", numOnDevice)
+	}
+
+	_, err = b.NewStreamPoolOnDevices([]int{4}, 2, 0)
+	if err == nil {
+		t.Error("creating streams on a device that doesn't exist should be an error")
+	}
+}
+
+// Streams should be handed out from whichever device has the fewest checked
+// out
+func TestStreamPool_BalancesDevices(t *testing.T) {
+	b := gpuBackend{dev: emulatedDevice{devices: 3}}
+	pool, err := b.NewStreamPoolOnDevices([]int{0, 1, 2}, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy := make(map[int][]Stream)
+	for i := 0; i < 3; i++ {
+		s := pool.TakeStream()
+		busy[s.device] = append(busy[s.device], s)
+	}
+	if len(busy) != 3 {
+		t.Fatalf("the first three streams should be on different devices, got %v", busy)
+	}
+	s := pool.TakeStream()
+	busy[s.device] = append(busy[s.device], s)
+
+	// The device with two streams out gets one back, so it's tied with the
+	// one device that still has one out. The third device is busiest now.
+	loaded := s.device
+	pool.ReturnStream(busy[loaded][0])
+	busy[loaded] = busy[loaded][1:]
+	var third int
+	for device := range busy {
+		if device != loaded && len(busy[device]) == 1 {
+			third = device
+		}
+	}
+	pool.ReturnStream(busy[third][0])
+	busy[third] = busy[third][1:]
+	s = pool.TakeStream()
+	if s.device != third {
+		t.Errorf("the stream should have come from idle device %v, but came from %v",
+			third, s.device)
+	}
+}
+
+// Chunks run concurrently on several devices should all get the right
+// results
+func TestEmulatedDevice_MultiDevice(t *testing.T) {
+	g := makeTestGroup2048()
+	b := gpuBackend{dev: emulatedDevice{devices: 2}}
+	env := mustChooseEnv(t, b.dev, g.GetP().BitLen())
+	pool, err := b.NewStreamPoolOnDevices([]int{0, 1}, 1,
+		env.streamSizeContaining(emulatedSlotsPerStream, kernelMul2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const numChunks = 4
+	var wg sync.WaitGroup
+	for i := 0; i < numChunks; i++ {
+		x := initRandomIntBuffer(g, emulatedNumSlots, int64(2*i), 0)
+		y := initRandomIntBuffer(g, emulatedNumSlots, int64(2*i+1), 0)
+		result := y.DeepCopy()
+		expected := y.DeepCopy()
+		for j := uint32(0); j < emulatedNumSlots; j++ {
+			cryptops.Mul2(g, x.Get(j), expected.Get(j))
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := b.Mul2Chunk(context.Background(), pool, g, x, y, result)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			checkSlots(t, expected, result)
+		}()
+	}
+	wg.Wait()
+}
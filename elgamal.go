@@ -7,16 +7,80 @@
 
 package gpumaths
 
-import "gitlab.com/elixxir/crypto/cyclic"
+import (
+	"context"
+	"gitlab.com/elixxir/crypto/cyclic"
+)
 
 // elgamal.go contains the input, results, and other types for running the
-// elgamal operation against the GPU. The actual GPU call is in elgamal_gpu.go
-// and is marked to require `-tags cuda` in your build.
+// elgamal operation on the active backend. The actual GPU call is in
+// elgamal_gpu.go and runs on CUDA when built with `-tags gpu`. The CPU
+// version is in elgamal_cpu.go.
 // ElGamalChunkPrototyp is the type necessary to implement cryptop interface
 type ElGamalChunkPrototype func(p *StreamPool, g *cyclic.Group,
 	key, privateKey *cyclic.IntBuffer, publicCypherKey *cyclic.Int,
 	ecrKey, cypher *cyclic.IntBuffer) error
 
+// ElGamalChunk performs the ElGamal operation on every slot, updating ecrKey
+// and cypher in place. It runs on the active backend.
+// All int buffers must have the same length, or ErrBufferLengthMismatch is
+// returned
+var ElGamalChunk ElGamalChunkPrototype = func(p *StreamPool, g *cyclic.Group,
+	key, privateKey *cyclic.IntBuffer, publicCypherKey *cyclic.Int,
+	ecrKey, cypher *cyclic.IntBuffer) error {
+	return ElGamalChunkContext(context.Background(), p, g, key, privateKey, publicCypherKey, ecrKey, cypher)
+}
+
+// ElGamalChunkContext is ElGamalChunk, but it takes any Ints, and it stops
+// between kernels (or slots, on the CPU) once ctx is done, and returns
+// ctx.Err(). Slots that hadn't been computed yet are left as they were.
+func ElGamalChunkContext(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	key, privateKey Ints, publicCypherKey *cyclic.Int, ecrKey, cypher Ints) error {
+	return ActiveBackend().ElGamalChunk(ctx, p, g, key, privateKey, publicCypherKey, ecrKey, cypher)
+}
+
+// ElGamalChunkAsync is ElGamalChunkContext, but it runs in the background.
+// The outputs can be used once the returned handle is done.
+func ElGamalChunkAsync(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	key, privateKey Ints, publicCypherKey *cyclic.Int, ecrKey, cypher Ints) *ChunkHandle {
+	b := ActiveBackend()
+	return goChunk(func() error {
+		return b.ElGamalChunk(ctx, p, g, key, privateKey, publicCypherKey, ecrKey, cypher)
+	})
+}
+
+// ElGamalSlicePrototype is ElGamalChunkPrototype for slices of cyclic ints
+type ElGamalSlicePrototype func(p *StreamPool, g *cyclic.Group,
+	key, privateKey []*cyclic.Int, publicCypherKey *cyclic.Int,
+	ecrKey, cypher []*cyclic.Int) error
+
+// ElGamalSlice is ElGamalChunk, but it takes slices of cyclic ints instead of
+// int buffers
+var ElGamalSlice ElGamalSlicePrototype = func(p *StreamPool, g *cyclic.Group,
+	key, privateKey []*cyclic.Int, publicCypherKey *cyclic.Int,
+	ecrKey, cypher []*cyclic.Int) error {
+	return ElGamalSliceContext(context.Background(), p, g, key, privateKey, publicCypherKey, ecrKey, cypher)
+}
+
+// ElGamalSliceContext is ElGamalSlice, but it stops between kernels (or
+// slots, on the CPU) once ctx is done, and returns ctx.Err(). Slots that
+// hadn't been computed yet are left as they were.
+func ElGamalSliceContext(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	key, privateKey []*cyclic.Int, publicCypherKey *cyclic.Int,
+	ecrKey, cypher []*cyclic.Int) error {
+	return ElGamalChunkContext(ctx, p, g, IntSlice(key), IntSlice(privateKey),
+		publicCypherKey, IntSlice(ecrKey), IntSlice(cypher))
+}
+
+// ElGamalSliceAsync is ElGamalSliceContext, but it runs in the background.
+// The outputs can be used once the returned handle is done.
+func ElGamalSliceAsync(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	key, privateKey []*cyclic.Int, publicCypherKey *cyclic.Int,
+	ecrKey, cypher []*cyclic.Int) *ChunkHandle {
+	return ElGamalChunkAsync(ctx, p, g, IntSlice(key), IntSlice(privateKey),
+		publicCypherKey, IntSlice(ecrKey), IntSlice(cypher))
+}
+
 // GetInputSize returns the chunk size for the op
 func (ElGamalChunkPrototype) GetInputSize() uint32 {
 	return 64
@@ -26,3 +90,13 @@ func (ElGamalChunkPrototype) GetInputSize() uint32 {
 func (ElGamalChunkPrototype) GetName() string {
 	return "ElGamalChunk"
 }
+
+// GetInputSize returns the chunk size for the op
+func (ElGamalSlicePrototype) GetInputSize() uint32 {
+	return 64
+}
+
+// GetName returns the name of the op (ElGamalSlice)
+func (ElGamalSlicePrototype) GetName() string {
+	return "ElGamalSlice"
+}
@@ -5,16 +5,59 @@
 // LICENSE file.                                                              //
 ////////////////////////////////////////////////////////////////////////////////
 
-//+build !linux !gpu
-
 package gpumaths
 
 import (
-	"errors"
+	"context"
 	"gitlab.com/elixxir/crypto/cyclic"
+	"math/bits"
 )
 
-// ElGamalChunk is stubbed unless GPU is present.
-var ElGamalChunk ElGamalChunkPrototype = func(p *StreamPool, g *cyclic.Group, key, privateKey *cyclic.IntBuffer, publicCypherKey *cyclic.Int, ecrKey, cypher *cyclic.IntBuffer) error {
-	return errors.New(NoGpuErrStr)
+// ElGamalChunk performs the ElGamal operation on the CPU, updating ecrKey and
+// cypher in place for every slot in the same way as cryptops.ElGamal does.
+// Slots are computed in parallel across all available cores, and the powers
+// of g are looked up in one fixed-base table shared by the whole batch.
+// Precondition: All int buffers must have the same length
+func (cpuBackend) ElGamalChunk(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	key, privateKey Ints, publicCypherKey *cyclic.Int,
+	ecrKey, cypher Ints) error {
+	err := checkLengths("ElGamalChunk", key.Len(), privateKey.Len(), ecrKey.Len(), cypher.Len())
+	if err != nil {
+		return err
+	}
+	err = p.validate("ElGamalChunk", g, elements("key", key), exponents("privateKey", privateKey),
+		constElement("publicCypherKey", publicCypherKey), elements("ecrKey", ecrKey),
+		elements("cypher", cypher))
+	if err != nil {
+		return err
+	}
+	numSlots := uint32(ecrKey.Len())
+	release, err := p.acquire(ctx, "ElGamalChunk", numSlots)
+	if err != nil {
+		return err
+	}
+
+	// The table only needs to cover the longest private key in the batch
+	maxKeyBits := 0
+	for i := uint32(0); i < numSlots; i++ {
+		keyBits := len(privateKey.Get(i).Bits()) * bits.UintSize
+		if keyBits > maxKeyBits {
+			maxKeyBits = keyBits
+		}
+	}
+	generator := g.NewIntFromLargeInt(g.GetG())
+	gTable := newFixedBaseTable(g, generator, maxKeyBits)
+
+	return release(forEachSlotContext(ctx, numSlots, func(i uint32) {
+		tmp := g.NewMaxInt()
+
+		// ecrKey = ecrKey*key*(g**privateKey) mod p
+		gTable.exp(privateKey.Get(i), tmp)
+		g.Mul(key.Get(i), tmp, tmp)
+		g.Mul(tmp, ecrKey.Get(i), ecrKey.Get(i))
+
+		// cypher = cypher*(publicCypherKey**privateKey) mod p
+		g.Exp(publicCypherKey, privateKey.Get(i), tmp)
+		g.Mul(tmp, cypher.Get(i), cypher.Get(i))
+	}))
 }
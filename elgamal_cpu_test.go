@@ -0,0 +1,48 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
+	"testing"
+)
+
+// CPU ElGamalChunk results should match cryptops.ElGamal slot for slot, with
+// both short and full length private keys
+func TestCpuBackend_ElGamalChunk(t *testing.T) {
+	for _, privateKeyLen := range []int{256 / 8, 0} {
+		const numSlots = 20
+		g := makeTestGroup2048()
+		key := initRandomIntBuffer(g, numSlots, 42, 0)
+		privateKey := initRandomIntBuffer(g, numSlots, 43, privateKeyLen)
+		publicCypherKey := g.Random(g.NewInt(1))
+		ecrKey := initRandomIntBuffer(g, numSlots, 44, 0)
+		cypher := initRandomIntBuffer(g, numSlots, 45, 0)
+		goEcrKey := ecrKey.DeepCopy()
+		goCypher := cypher.DeepCopy()
+
+		err := cpuBackend{}.ElGamalChunk(context.Background(), nil, g, key, privateKey, publicCypherKey, ecrKey, cypher)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := uint32(0); i < numSlots; i++ {
+			cryptops.ElGamal(g, key.Get(i), privateKey.Get(i), publicCypherKey,
+				goEcrKey.Get(i), goCypher.Get(i))
+			if goEcrKey.Get(i).Cmp(ecrKey.Get(i)) != 0 {
+				t.Errorf("Go EcrKey (%+v) didn't match ElGamalChunk results (%+v) in slot %v",
+					goEcrKey.Get(i).Text(16), ecrKey.Get(i).Text(16), i)
+			}
+			if goCypher.Get(i).Cmp(cypher.Get(i)) != 0 {
+				t.Errorf("Go cypher (%+v) didn't match ElGamalChunk results (%+v) in slot %v",
+					goCypher.Get(i).Text(16), cypher.Get(i).Text(16), i)
+			}
+		}
+	}
+}
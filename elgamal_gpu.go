@@ -5,139 +5,118 @@
 // LICENSE file.                                                              //
 ////////////////////////////////////////////////////////////////////////////////
 
-//+build linux,gpu
-
 package gpumaths
 
-/*#cgo LDFLAGS: -Llib -lpowmosm75 -Wl,-rpath -Wl,./lib:/opt/xxnetwork/lib
-#cgo CFLAGS: -I./cgbnBindings/powm -I/opt/xxnetwork/include
-#include <powm_odd_export.h>
-*/
-import "C"
-
 import (
-	jww "github.com/spf13/jwalterweatherman"
+	"context"
 	"gitlab.com/elixxir/crypto/cyclic"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
 )
 
-// elgamal_gpu.go contains the CUDA ops for the ElGamal operation. ElGamal(...)
-// performs the actual call into the library and ElGamalChunk implements
-// the streaming interface function called by the server implementation.
-
-const (
-	kernelElgamal = C.KERNEL_ELGAMAL
-)
+// elgamal_gpu.go contains the gpu ops for the ElGamal operation.
+// putElGamal(...) and importElGamal(...) lay out the stream's buffer for the
+// library's kernel, and ElGamalChunk implements the streaming interface
+// function called by the server implementation.
 
 // Precondition: All int buffers must have the same length
 // Perform the ElGamal operation on two int buffers
-var ElGamalChunk ElGamalChunkPrototype = func(p *StreamPool, g *cyclic.Group,
-	key, privateKey *cyclic.IntBuffer, publicCypherKey *cyclic.Int,
-	ecrKey, cypher *cyclic.IntBuffer) error {
-	// Populate ElGamal inputs
-	numSlots := uint32(ecrKey.Len())
-
-	env := chooseEnv(g)
-
-	// Run kernel on the inputs
-	stream := p.TakeStream()
-	defer p.ReturnStream(stream)
-	maxSlotsElGamal := uint32(env.maxSlots(len(stream.cpuData), kernelElgamal))
-	if numSlots > maxSlotsElGamal {
-		jww.WARN.Printf("Running multiple kernels for ElgamalChunk. Performance may be degraded")
+func (b gpuBackend) ElGamalChunk(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	key, privateKey Ints, publicCypherKey *cyclic.Int,
+	ecrKey, cypher Ints) error {
+	err := checkLengths("ElGamalChunk", key.Len(), privateKey.Len(), ecrKey.Len(), cypher.Len())
+	if err != nil {
+		return err
 	}
-	for i := uint32(0); i < numSlots; i += maxSlotsElGamal {
-		sliceEnd := i
-		// Don't slice beyond the end of the input slice
-		if i+maxSlotsElGamal <= numSlots {
-			sliceEnd += maxSlotsElGamal
-		} else {
-			sliceEnd = numSlots
-		}
-		err := <-elGamal(g, key.GetSubBuffer(i, sliceEnd), privateKey.GetSubBuffer(i, sliceEnd),
-			publicCypherKey, ecrKey.GetSubBuffer(i, sliceEnd), cypher.GetSubBuffer(i, sliceEnd), env, stream)
-		if err != nil {
-			return err
-		}
+	err = p.validate("ElGamalChunk", g, elements("key", key), exponents("privateKey", privateKey),
+		constElement("publicCypherKey", publicCypherKey), elements("ecrKey", ecrKey),
+		elements("cypher", cypher))
+	if err != nil {
+		return err
 	}
-
-	return nil
+	return b.runChunk(ctx, p, g, gpuOp{
+		name:     "ElGamalChunk",
+		kernel:   kernelElgamal,
+		numSlots: uint32(ecrKey.Len()),
+		put: func(env gpumathsEnv, stream Stream, start, end uint32) {
+			putElGamal(g, subInts(key, start, end), subInts(privateKey, start, end),
+				publicCypherKey, subInts(ecrKey, start, end), subInts(cypher, start, end),
+				env, stream)
+		},
+		importResults: func(env gpumathsEnv, stream Stream, start, end uint32) {
+			importElGamal(g, subInts(ecrKey, start, end), subInts(cypher, start, end),
+				env, stream)
+		},
+		cpu: func(start, end uint32) error {
+			return cpuBackend{}.ElGamalChunk(ctx, nil, g, subInts(key, start, end),
+				subInts(privateKey, start, end), publicCypherKey,
+				subInts(ecrKey, start, end), subInts(cypher, start, end))
+		},
+		check: func(slot uint32) func() bool {
+			keySlot, privateKeySlot := key.Get(slot).DeepCopy(), privateKey.Get(slot).DeepCopy()
+			ecrKeySlot, cypherSlot := ecrKey.Get(slot).DeepCopy(), cypher.Get(slot).DeepCopy()
+			return func() bool {
+				cryptops.ElGamal(g, keySlot, privateKeySlot, publicCypherKey, ecrKeySlot, cypherSlot)
+				return ecrKeySlot.Cmp(ecrKey.Get(slot)) == 0 && cypherSlot.Cmp(cypher.Get(slot)) == 0
+			}
+		},
+	})
 }
 
-// ElGamal runs the op on the GPU
-// publicCypherKey and prime should be byte slices obtained by running
-// .Bytes() on the large int
-// The resulting byte slice should be trimmed and should be less than or
-// equal to the length of the template-instantiated BN on the GPU.
-// bnLength is a length in bits
-// TODO validate BN length in code (i.e. pick kernel variants based on bn length)
-func elGamal(g *cyclic.Group, key, privateKey *cyclic.IntBuffer, publicCypherKey *cyclic.Int,
-	ecrKey, cypher *cyclic.IntBuffer, env gpumathsEnv, stream Stream) chan error {
-	// Return the result later, when the GPU job finishes
-	resultChan := make(chan error, 1)
-	go func() {
-		// Arrange memory into stream buffers
-		numSlots := uint32(key.Len())
-
-		// TODO clean this up by implementing the
-		// arrangement/dearrangement with reader/writer interfaces
-		//  or smth
-		constants := stream.getCpuConstantsWords(env, kernelElgamal)
-		offset := 0
-		bnLengthWords := env.getWordLen()
-		putBits(constants[offset:offset+bnLengthWords], g.GetG().Bits(),
-			bnLengthWords)
+// putElGamal arranges the constants and each slot's keys and cypher in the
+// stream's buffer
+func putElGamal(g *cyclic.Group, key, privateKey Ints, publicCypherKey *cyclic.Int,
+	ecrKey, cypher Ints, env gpumathsEnv, stream Stream) {
+	// Arrange memory into stream buffers
+	numSlots := uint32(key.Len())
+
+	// TODO clean this up by implementing the
+	// arrangement/dearrangement with reader/writer interfaces
+	//  or smth
+	constants := stream.getCpuConstantsWords(env, kernelElgamal)
+	offset := 0
+	bnLengthWords := env.getWordLen()
+	putBits(constants[offset:offset+bnLengthWords], g.GetG().Bits(),
+		bnLengthWords)
+	offset += bnLengthWords
+	putBits(constants[offset:offset+bnLengthWords],
+		g.GetP().Bits(), bnLengthWords)
+	offset += bnLengthWords
+	putBits(constants[offset:offset+bnLengthWords],
+		publicCypherKey.Bits(), bnLengthWords)
+
+	inputs := stream.getCpuInputsWords(env, kernelElgamal, int(numSlots))
+	offset = 0
+	for i := uint32(0); i < numSlots; i++ {
+		putBits(inputs[offset:offset+bnLengthWords],
+			privateKey.Get(i).Bits(), bnLengthWords)
 		offset += bnLengthWords
-		putBits(constants[offset:offset+bnLengthWords],
-			g.GetP().Bits(), bnLengthWords)
+		putBits(inputs[offset:offset+bnLengthWords],
+			key.Get(i).Bits(), bnLengthWords)
 		offset += bnLengthWords
-		putBits(constants[offset:offset+bnLengthWords],
-			publicCypherKey.Bits(), bnLengthWords)
-
-		inputs := stream.getCpuInputsWords(env, kernelElgamal, int(numSlots))
-		offset = 0
-		for i := uint32(0); i < numSlots; i++ {
-			putBits(inputs[offset:offset+bnLengthWords],
-				privateKey.Get(i).Bits(), bnLengthWords)
-			offset += bnLengthWords
-			putBits(inputs[offset:offset+bnLengthWords],
-				key.Get(i).Bits(), bnLengthWords)
-			offset += bnLengthWords
-			putBits(inputs[offset:offset+bnLengthWords],
-				ecrKey.Get(i).Bits(), bnLengthWords)
-			offset += bnLengthWords
-			putBits(inputs[offset:offset+bnLengthWords],
-				cypher.Get(i).Bits(), bnLengthWords)
-			offset += bnLengthWords
-		}
-
-		// Upload, run, wait for download
-		err := env.enqueue(stream, kernelElgamal, int(numSlots))
-		if err != nil {
-			resultChan <- err
-			return
-		}
-		// Results will be stored in this buffer
-		results := stream.getCpuOutputsWords(env, kernelElgamal, int(numSlots))
-
-		// Wait on things to finish with Cuda
-		err = get(stream)
-		if err != nil {
-			resultChan <- err
-			return
-		}
-
-		// Everything is OK, so let's go ahead and import the results
-		offset = 0
-		for i := uint32(0); i < numSlots; i++ {
-			end := offset + bnLengthWords
-			g.OverwriteBits(ecrKey.Get(i), results[offset:end])
-			offset += bnLengthWords
-			end = offset + bnLengthWords
-			g.OverwriteBits(cypher.Get(i), results[offset:end])
-			offset += bnLengthWords
-		}
+		putBits(inputs[offset:offset+bnLengthWords],
+			ecrKey.Get(i).Bits(), bnLengthWords)
+		offset += bnLengthWords
+		putBits(inputs[offset:offset+bnLengthWords],
+			cypher.Get(i).Bits(), bnLengthWords)
+		offset += bnLengthWords
+	}
+}
 
-		resultChan <- nil
-	}()
-	return resultChan
+// importElGamal copies each slot's new ecrKey and cypher out of the
+// stream's buffer
+func importElGamal(g *cyclic.Group, ecrKey, cypher Ints, env gpumathsEnv, stream Stream) {
+	numSlots := uint32(ecrKey.Len())
+	bnLengthWords := env.getWordLen()
+	// Results will be stored in this buffer
+	results := stream.getCpuOutputsWords(env, kernelElgamal, int(numSlots))
+
+	offset := 0
+	for i := uint32(0); i < numSlots; i++ {
+		end := offset + bnLengthWords
+		g.OverwriteBits(ecrKey.Get(i), results[offset:end])
+		offset += bnLengthWords
+		end = offset + bnLengthWords
+		g.OverwriteBits(cypher.Get(i), results[offset:end])
+		offset += bnLengthWords
+	}
 }
@@ -10,8 +10,10 @@
 package gpumaths
 
 import (
+	"context"
 	"gitlab.com/elixxir/gpumathsgo/cryptops"
 	"gitlab.com/elixxir/crypto/cyclic"
+	"sync"
 	"testing"
 )
 
@@ -176,18 +178,20 @@ func BenchmarkElGamalCUDA4096_256_streams(b *testing.B) {
 	const yBitLen = 4096
 	const yByteLen = yBitLen / 8
 	g := makeTestGroup4096()
-	env := &gpumathsEnv4096
+	env := mustChooseEnv(b, cudaDevice{}, 4096)
 	// Use two streams with 32k items per kernel launch
 	numItems := 32768
 
 	// OK, this shouldn't cause the test to run forever if the stream size is smaller than it should be (like this)
 	// In real-world usage, the number of slots passed in should be determined by what the stream supports
 	//  (i.e. check stream.MaxSlotsElgamal)
-	streamPool, err := NewStreamPool(2, env.streamSizeContaining(numItems, kernelElgamal))
+	gpu := gpuBackend{dev: cudaDevice{}}
+	streamPool, err := gpu.NewStreamPool(2, env.streamSizeContaining(numItems, kernelElgamal))
 	if err != nil {
 		b.Fatal(err)
 	}
 	// Using prng because the cryptographically secure RNG used by the group is too slow to feed the GPU
+	var wg sync.WaitGroup
 	b.ResetTimer()
 	remainingItems := b.N
 	for i := 0; i < b.N; i += numItems {
@@ -203,19 +207,18 @@ func BenchmarkElGamalCUDA4096_256_streams(b *testing.B) {
 		ecrKey := initRandomIntBuffer(g, uint32(numItemsToUpload), 43, xByteLen)
 		cypher := initRandomIntBuffer(g, uint32(numItemsToUpload), 44, xByteLen)
 		privateKey := initRandomIntBuffer(g, uint32(numItemsToUpload), 45, yByteLen)
-		stream := streamPool.TakeStream()
-		resultChan := elGamal(g, key, privateKey, PublicCypherKey, ecrKey, cypher, env, stream)
+		wg.Add(1)
 		go func() {
-			err := <-resultChan
-			streamPool.ReturnStream(stream)
+			defer wg.Done()
+			err := gpu.ElGamalChunk(context.Background(), streamPool, g, key, privateKey,
+				PublicCypherKey, ecrKey, cypher)
 			if err != nil {
-				b.Fatal(err)
+				b.Error(err)
 			}
 		}()
 	}
-	// Empty the pool to make sure results have all been downloaded
-	streamPool.TakeStream()
-	streamPool.TakeStream()
+	// Wait for the results to all be downloaded
+	wg.Wait()
 	b.StopTimer()
 	err = streamPool.Destroy()
 	if err != nil {
@@ -0,0 +1,199 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"github.com/pkg/errors"
+	"gitlab.com/xx_network/crypto/large"
+	"math/big"
+	"unsafe"
+)
+
+// emulated.go contains a device that runs the gpu implementation's kernels
+// in Go with math/big. The emulated device lays out its streams' buffers the
+// same way the native library does, so the gpu implementation's marshalling
+// code can be tested on machines without a GPU.
+
+// Largest buffer an emulated stream can have
+const maxEmulatedCapacity = 1 << 30
+
+// emulatedDevice runs kernels on the CPU
+type emulatedDevice struct {
+	// How many devices to pretend there are. Zero means one.
+	devices int
+}
+
+// emulatedStream is what an emulated Stream's s points to
+type emulatedStream struct {
+	// Error from the last enqueued kernel, reported by get
+	err error
+}
+
+// The emulated device can run kernels at any width, so it has more of them
+// than the native library is built for
+var emulatedEnvs = newEnvRegistry(
+	emulatedWidth(1024),
+	emulatedWidth(1536),
+	emulatedWidth(2048),
+	emulatedWidth(3072),
+	emulatedWidth(3200),
+	emulatedWidth(4096),
+	emulatedWidth(6144),
+	emulatedWidth(8192),
+)
+
+func (emulatedDevice) init() error {
+	return nil
+}
+
+func (d emulatedDevice) numDevices() (int, error) {
+	if d.devices == 0 {
+		return 1, nil
+	}
+	return d.devices, nil
+}
+
+// Creates streams with buffers in Go memory. cpuData and cpuDataWords view
+// the same memory, like they do for CUDA streams
+func (d emulatedDevice) createStreams(ordinal int, numStreams int, capacity int) ([]Stream, error) {
+	numDevices, _ := d.numDevices()
+	if ordinal < 0 || ordinal >= numDevices {
+		return nil, errors.Errorf("there's no emulated device %v", ordinal)
+	}
+	if capacity > maxEmulatedCapacity {
+		return nil, errors.Errorf("emulated streams can't be bigger than %v bytes", maxEmulatedCapacity)
+	}
+	wordSize := int(unsafe.Sizeof(big.Word(0)))
+	streams := make([]Stream, 0, numStreams)
+	for i := 0; i < numStreams; i++ {
+		// Round up so the byte view never goes past the end of the words
+		words := make(large.Bits, (capacity+wordSize-1)/wordSize)
+		var data []byte
+		if capacity > 0 {
+			data = (*[maxEmulatedCapacity]byte)(unsafe.Pointer(&words[0]))[:capacity:capacity]
+		}
+		streams = append(streams, Stream{
+			device:       ordinal,
+			s:            unsafe.Pointer(&emulatedStream{}),
+			cpuData:      data,
+			cpuDataWords: words[:capacity/wordSize],
+		})
+	}
+	return streams, nil
+}
+
+func (emulatedDevice) destroyStreams(streams []Stream) error {
+	return nil
+}
+
+func (emulatedDevice) chooseEnv(bitLen int) (gpumathsEnv, error) {
+	env, err := emulatedEnvs.choose(bitLen)
+	if err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// emulatedWidth is the emulated device's row in an env registry for numbers
+// of bitLen bits. Sizes come from kernelLayouts instead of the native library
+func emulatedWidth(bitLen int) envWidth {
+	width := layoutWidth(bitLen)
+	width.enqueue = emulatedEnqueue
+	width.get = emulatedGet
+	return width
+}
+
+// Runs the kernel on the stream's buffer straight away
+// Errors from running the kernel are kept for get, like the CGBN error report
+func emulatedEnqueue(e gpumathsEnv, stream Stream, whichToRun kernel, numSlots int) error {
+	es := (*emulatedStream)(stream.s)
+	if whichToRun < 0 || whichToRun >= numKernels {
+		return errors.Wrapf(ErrUnknownKernel, "kernel %v", whichToRun)
+	}
+	if e.streamSizeContaining(numSlots, whichToRun) > len(stream.cpuData) {
+		return errors.Errorf("%v slots for kernel %v don't fit in a %v byte stream",
+			numSlots, whichToRun, len(stream.cpuData))
+	}
+
+	layout := kernelLayouts[whichToRun]
+	wordLen := e.getWordLen()
+	// Reads numbers out of a region of the buffer
+	read := func(words large.Bits, n int) []*big.Int {
+		result := make([]*big.Int, n)
+		for i := range result {
+			result[i] = bigFromBits(words[i*wordLen : (i+1)*wordLen])
+		}
+		return result
+	}
+
+	constants := read(stream.getCpuConstantsWords(e, whichToRun), layout.constants)
+	inputs := stream.getCpuInputsWords(e, whichToRun, numSlots)
+	outputs := stream.getCpuOutputsWords(e, whichToRun, numSlots)
+	es.err = nil
+	for i := 0; i < numSlots; i++ {
+		slotInputs := read(inputs[i*layout.inputs*wordLen:], layout.inputs)
+		slotOutputs, err := runEmulatedKernel(whichToRun, constants, slotInputs)
+		if err != nil {
+			es.err = errors.Wrapf(err, "slot %v", i)
+			return nil
+		}
+		for j, output := range slotOutputs {
+			start := (i*layout.outputs + j) * wordLen
+			putBits(outputs[start:start+wordLen], output.Bits(), wordLen)
+		}
+	}
+	return nil
+}
+
+// Reports the error from the last kernel enqueued on the stream
+func emulatedGet(stream Stream) error {
+	es := (*emulatedStream)(stream.s)
+	err := es.err
+	es.err = nil
+	return err
+}
+
+// runEmulatedKernel computes one slot of a kernel. The constants and inputs
+// are in the same order as they are in the stream's buffer, and so are the
+// outputs that are returned
+func runEmulatedKernel(k kernel, constants, inputs []*big.Int) ([]*big.Int, error) {
+	switch k {
+	case kernelPowmOdd:
+		p := constants[0]
+		return []*big.Int{new(big.Int).Exp(inputs[0], inputs[1], p)}, nil
+	case kernelElgamal:
+		g, p, publicCypherKey := constants[0], constants[1], constants[2]
+		privateKey, key, ecrKey, cypher := inputs[0], inputs[1], inputs[2], inputs[3]
+		// ecrKey = ecrKey * key * g**privateKey
+		newEcrKey := new(big.Int).Exp(g, privateKey, p)
+		newEcrKey.Mul(newEcrKey, key).Mod(newEcrKey, p)
+		newEcrKey.Mul(newEcrKey, ecrKey).Mod(newEcrKey, p)
+		// cypher = cypher * publicCypherKey**privateKey
+		newCypher := new(big.Int).Exp(publicCypherKey, privateKey, p)
+		newCypher.Mul(newCypher, cypher).Mod(newCypher, p)
+		return []*big.Int{newEcrKey, newCypher}, nil
+	case kernelReveal:
+		p, publicCypherKey := constants[0], constants[1]
+		pMinus1 := new(big.Int).Sub(p, big.NewInt(1))
+		inverse := new(big.Int).ModInverse(publicCypherKey, pMinus1)
+		if inverse == nil {
+			return nil, errors.New("publicCypherKey is not coprime with p-1")
+		}
+		return []*big.Int{new(big.Int).Exp(inputs[0], inverse, p)}, nil
+	case kernelMul2:
+		p := constants[0]
+		result := new(big.Int).Mul(inputs[0], inputs[1])
+		return []*big.Int{result.Mod(result, p)}, nil
+	case kernelMul3:
+		p := constants[0]
+		result := new(big.Int).Mul(inputs[0], inputs[1])
+		result.Mod(result, p).Mul(result, inputs[2])
+		return []*big.Int{result.Mod(result, p)}, nil
+	}
+	return nil, errors.Wrapf(ErrUnknownKernel, "kernel %v", k)
+}
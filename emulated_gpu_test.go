@@ -0,0 +1,60 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+//+build linux,gpu
+
+package gpumaths
+
+import (
+	"reflect"
+	"testing"
+)
+
+// The emulated envs should lay out every kernel exactly like the native
+// library does, or the emulated tests could miss packing bugs
+func TestEmulatedEnv_MatchesCuda(t *testing.T) {
+	for _, bitLen := range cudaEnvs.bitLens() {
+		cudaEnv := mustChooseEnv(t, cudaDevice{}, bitLen)
+		emulated := mustChooseEnv(t, emulatedDevice{}, bitLen)
+		if emulated.getBitLen() != bitLen {
+			t.Fatalf("there was no emulated env for the %v bit cuda env", bitLen)
+		}
+		for k := kernel(0); k < numKernels; k++ {
+			if emulated.getConstantsSize(k) != cudaEnv.getConstantsSize(k) ||
+				emulated.getInputSize(k) != cudaEnv.getInputSize(k) ||
+				emulated.getOutputSize(k) != cudaEnv.getOutputSize(k) {
+				t.Errorf("%v bit kernel %v: emulated sizes (%v, %v, %v) didn't match cuda sizes (%v, %v, %v)",
+					cudaEnv.getBitLen(), k,
+					emulated.getConstantsSize(k), emulated.getInputSize(k), emulated.getOutputSize(k),
+					cudaEnv.getConstantsSize(k), cudaEnv.getInputSize(k), cudaEnv.getOutputSize(k))
+			}
+		}
+	}
+}
+
+// The layouts that capacity planning uses should be the native library's
+func TestNativeLayouts_MatchCuda(t *testing.T) {
+	if !reflect.DeepEqual(nativeLayouts.bitLens(), cudaEnvs.bitLens()) {
+		t.Fatalf("native layouts have widths %v, but the native library has %v",
+			nativeLayouts.bitLens(), cudaEnvs.bitLens())
+	}
+	for _, bitLen := range cudaEnvs.bitLens() {
+		cudaEnv := mustChooseEnv(t, cudaDevice{}, bitLen)
+		for op := Op(0); op < numOps; op++ {
+			layout, err := GetOpLayout(bitLen, op)
+			if err != nil {
+				t.Fatal(err)
+			}
+			k := opKernels[op]
+			if layout.ConstantsSize != cudaEnv.getConstantsSize(k) ||
+				layout.InputSize != cudaEnv.getInputSize(k) ||
+				layout.OutputSize != cudaEnv.getOutputSize(k) {
+				t.Errorf("%v bit %v: layout %+v didn't match the native library", bitLen, op, layout)
+			}
+		}
+	}
+}
@@ -0,0 +1,364 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"gitlab.com/elixxir/crypto/cyclic"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
+	"testing"
+)
+
+// The batches are bigger than a stream can hold, so every op gets split up
+// into several kernels, the last of which isn't full
+const (
+	emulatedSlotsPerStream = 3
+	emulatedNumSlots       = 7
+)
+
+// Groups for every emulated env width
+var emulatedTestGroups = []struct {
+	name string
+	make func() *cyclic.Group
+}{
+	{"2048", makeTestGroup2048},
+	{"3072", makeTestGroup3072},
+	{"4096", makeTestGroup4096},
+}
+
+// mustChooseEnv gets the device's env for primes of bitLen bits, failing the
+// test if there isn't one
+func mustChooseEnv(t testing.TB, d device, bitLen int) gpumathsEnv {
+	env, err := d.chooseEnv(bitLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+// newEmulatedPool makes a pool on the emulated device whose streams have room
+// for emulatedSlotsPerStream slots of the kernel
+func newEmulatedPool(t *testing.T, g *cyclic.Group, k kernel) (gpuBackend, *StreamPool) {
+	b := gpuBackend{dev: emulatedDevice{}}
+	env := mustChooseEnv(t, b.dev, g.GetP().BitLen())
+	pool, err := b.NewStreamPool(1, env.streamSizeContaining(emulatedSlotsPerStream, k))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, pool
+}
+
+// testHooks let a test watch or break the emulated device. Any of them can be
+// nil.
+type testHooks struct {
+	// onEnqueue is called before each kernel is enqueued
+	onEnqueue func(stream Stream, k kernel, numSlots int)
+	// onGet is called after each kernel comes back, and fails the kernel if
+	// it returns an error
+	onGet func(stream Stream) error
+	// createStreams and destroyStreams replace the emulated device's
+	createStreams  func(ordinal int, numStreams int, capacity int) ([]Stream, error)
+	destroyStreams func(streams []Stream) error
+}
+
+// testDevice is an emulated device that calls its hooks
+type testDevice struct {
+	emulatedDevice
+	hooks testHooks
+}
+
+type testEnv struct {
+	*tableEnv
+	hooks testHooks
+}
+
+func (d testDevice) createStreams(ordinal int, numStreams int, capacity int) ([]Stream, error) {
+	if d.hooks.createStreams != nil {
+		return d.hooks.createStreams(ordinal, numStreams, capacity)
+	}
+	return d.emulatedDevice.createStreams(ordinal, numStreams, capacity)
+}
+
+func (d testDevice) destroyStreams(streams []Stream) error {
+	if d.hooks.destroyStreams != nil {
+		return d.hooks.destroyStreams(streams)
+	}
+	return d.emulatedDevice.destroyStreams(streams)
+}
+
+func (d testDevice) chooseEnv(bitLen int) (gpumathsEnv, error) {
+	env, err := d.emulatedDevice.chooseEnv(bitLen)
+	if err != nil {
+		return nil, err
+	}
+	return &testEnv{tableEnv: env.(*tableEnv), hooks: d.hooks}, nil
+}
+
+func (e *testEnv) enqueue(stream Stream, k kernel, numSlots int) error {
+	if e.hooks.onEnqueue != nil {
+		e.hooks.onEnqueue(stream, k, numSlots)
+	}
+	return e.tableEnv.enqueue(stream, k, numSlots)
+}
+
+func (e *testEnv) get(stream Stream) error {
+	err := e.tableEnv.get(stream)
+	if err == nil && e.hooks.onGet != nil {
+		err = e.hooks.onGet(stream)
+	}
+	return err
+}
+
+// newTestPool makes a pool of numStreams streams on a test device, with room
+// for emulatedSlotsPerStream slots of the kernel each
+func newTestPool(t *testing.T, g *cyclic.Group, k kernel, numStreams int,
+	hooks testHooks) (gpuBackend, *StreamPool) {
+	b := gpuBackend{dev: testDevice{hooks: hooks}}
+	env := mustChooseEnv(t, b.dev, g.GetP().BitLen())
+	pool, err := b.NewStreamPool(numStreams, env.streamSizeContaining(emulatedSlotsPerStream, k))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, pool
+}
+
+// Checks results against what the same op computed with cryptops
+func checkSlots(t *testing.T, expected, actual *cyclic.IntBuffer) {
+	for i := uint32(0); i < uint32(expected.Len()); i++ {
+		if expected.Get(i).Cmp(actual.Get(i)) != 0 {
+			t.Errorf("Go results (%+v) didn't match emulated results (%+v) in slot %v",
+				expected.Get(i).Text(16), actual.Get(i).Text(16), i)
+		}
+	}
+}
+
+func TestEmulatedDevice_ExpChunk(t *testing.T) {
+	for _, tg := range emulatedTestGroups {
+		t.Run(tg.name, func(t *testing.T) {
+			g := tg.make()
+			b, pool := newEmulatedPool(t, g, kernelPowmOdd)
+			x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+			y := initRandomIntBuffer(g, emulatedNumSlots, 2, 32)
+			z := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+
+			_, err := b.ExpChunk(context.Background(), pool, g, x, y, z)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+			for i := uint32(0); i < emulatedNumSlots; i++ {
+				cryptops.Exp(g, x.Get(i), y.Get(i), expected.Get(i))
+			}
+			checkSlots(t, expected, z)
+		})
+	}
+}
+
+func TestEmulatedDevice_ElGamalChunk(t *testing.T) {
+	for _, tg := range emulatedTestGroups {
+		t.Run(tg.name, func(t *testing.T) {
+			g := tg.make()
+			b, pool := newEmulatedPool(t, g, kernelElgamal)
+			key := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+			privateKey := initRandomIntBuffer(g, emulatedNumSlots, 2, 32)
+			publicCypherKey := initRandomIntBuffer(g, 1, 3, 0).Get(0)
+			ecrKey := initRandomIntBuffer(g, emulatedNumSlots, 4, 0)
+			cypher := initRandomIntBuffer(g, emulatedNumSlots, 5, 0)
+			expectedEcrKey := ecrKey.DeepCopy()
+			expectedCypher := cypher.DeepCopy()
+
+			err := b.ElGamalChunk(context.Background(), pool, g, key, privateKey, publicCypherKey, ecrKey, cypher)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := uint32(0); i < emulatedNumSlots; i++ {
+				cryptops.ElGamal(g, key.Get(i), privateKey.Get(i), publicCypherKey,
+					expectedEcrKey.Get(i), expectedCypher.Get(i))
+			}
+			checkSlots(t, expectedEcrKey, ecrKey)
+			checkSlots(t, expectedCypher, cypher)
+		})
+	}
+}
+
+func TestEmulatedDevice_RevealChunk(t *testing.T) {
+	for _, tg := range emulatedTestGroups {
+		t.Run(tg.name, func(t *testing.T) {
+			g := tg.make()
+			b, pool := newEmulatedPool(t, g, kernelReveal)
+			publicCypherKey := g.FindSmallCoprimeInverse(g.NewInt(1), 256)
+			cypher := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+			result := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+
+			err := b.RevealChunk(context.Background(), pool, g, publicCypherKey, cypher, result)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+			for i := uint32(0); i < emulatedNumSlots; i++ {
+				cryptops.RootCoprime(g, cypher.Get(i), publicCypherKey, expected.Get(i))
+			}
+			checkSlots(t, expected, result)
+		})
+	}
+}
+
+func TestEmulatedDevice_Mul2Chunk(t *testing.T) {
+	for _, tg := range emulatedTestGroups {
+		t.Run(tg.name, func(t *testing.T) {
+			g := tg.make()
+			b, pool := newEmulatedPool(t, g, kernelMul2)
+			x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+			y := initRandomIntBuffer(g, emulatedNumSlots, 2, 0)
+			result := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+			sliceResult := make([]*cyclic.Int, emulatedNumSlots)
+			ySlice := make([]*cyclic.Int, emulatedNumSlots)
+			for i := range sliceResult {
+				sliceResult[i] = g.NewInt(1)
+				ySlice[i] = y.Get(uint32(i))
+			}
+
+			err := b.Mul2Chunk(context.Background(), pool, g, x, y, result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = b.Mul2Chunk(context.Background(), pool, g, x, IntSlice(ySlice), IntSlice(sliceResult))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := y.DeepCopy()
+			for i := uint32(0); i < emulatedNumSlots; i++ {
+				cryptops.Mul2(g, x.Get(i), expected.Get(i))
+				if expected.Get(i).Cmp(sliceResult[i]) != 0 {
+					t.Errorf("Mul2Chunk result with slices didn't match Go result in slot %v", i)
+				}
+			}
+			checkSlots(t, expected, result)
+		})
+	}
+}
+
+func TestEmulatedDevice_Mul3Chunk(t *testing.T) {
+	for _, tg := range emulatedTestGroups {
+		t.Run(tg.name, func(t *testing.T) {
+			g := tg.make()
+			b, pool := newEmulatedPool(t, g, kernelMul3)
+			x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+			y := initRandomIntBuffer(g, emulatedNumSlots, 2, 0)
+			z := initRandomIntBuffer(g, emulatedNumSlots, 3, 0)
+			result := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+
+			err := b.Mul3Chunk(context.Background(), pool, g, x, y, z, result)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := z.DeepCopy()
+			for i := uint32(0); i < emulatedNumSlots; i++ {
+				cryptops.Mul3(g, x.Get(i), y.Get(i), expected.Get(i))
+			}
+			checkSlots(t, expected, result)
+		})
+	}
+}
+
+// The regions for constants, inputs and outputs should be laid out one
+// after the other and take up exactly the stream's buffer
+func TestEmulatedEnv_Layout(t *testing.T) {
+	const numSlots = 5
+	for _, bitLen := range emulatedEnvs.bitLens() {
+		env := mustChooseEnv(t, emulatedDevice{}, bitLen)
+		for k := kernel(0); k < numKernels; k++ {
+			size := env.streamSizeContaining(numSlots, k)
+			if env.maxSlots(size, k) != numSlots || env.maxSlots(size-1, k) != numSlots-1 {
+				t.Errorf("%v bit kernel %v: a %v byte stream should fit exactly %v slots",
+					env.getBitLen(), k, size, numSlots)
+			}
+			streams, err := emulatedDevice{}.createStreams(0, 1, size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stream := streams[0]
+
+			constants := stream.getCpuConstantsWords(env, k)
+			inputs := stream.getCpuInputsWords(env, k, numSlots)
+			outputs := stream.getCpuOutputsWords(env, k, numSlots)
+			layout := kernelLayouts[k]
+			wordLen := env.getWordLen()
+			if len(constants) != layout.constants*wordLen ||
+				len(inputs) != layout.inputs*wordLen*numSlots ||
+				len(outputs) != layout.outputs*wordLen*numSlots {
+				t.Errorf("%v bit kernel %v: regions had the wrong lengths", env.getBitLen(), k)
+			}
+			if &constants[:len(constants)+1][len(constants)] != &inputs[0] ||
+				&inputs[:len(inputs)+1][len(inputs)] != &outputs[0] ||
+				len(constants)+len(inputs)+len(outputs) != len(stream.cpuDataWords) {
+				t.Errorf("%v bit kernel %v: regions weren't contiguous", env.getBitLen(), k)
+			}
+		}
+	}
+}
+
+// Enqueueing more slots than fit in the stream is an error, not a buffer overrun
+func TestEmulatedEnv_Enqueue_TooManySlots(t *testing.T) {
+	env := mustChooseEnv(t, emulatedDevice{}, 2048)
+	streams, err := emulatedDevice{}.createStreams(0, 1, env.streamSizeContaining(2, kernelMul2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.enqueue(streams[0], kernelMul2, 3)
+	if err == nil {
+		t.Error("enqueueing 3 slots on a stream with room for 2 should have failed")
+	}
+}
+
+// Errors computing a kernel are reported by get, like the CGBN error report
+func TestEmulatedEnv_Get_KernelError(t *testing.T) {
+	env := mustChooseEnv(t, emulatedDevice{}, 2048)
+	streams, err := emulatedDevice{}.createStreams(0, 1, env.streamSizeContaining(1, kernelReveal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := streams[0]
+	g := makeTestGroup2048()
+	constants := stream.getCpuConstantsWords(env, kernelReveal)
+	wordLen := env.getWordLen()
+	putBits(constants[:wordLen], g.GetP().Bits(), wordLen)
+	// p-1 is even, so 2 has no inverse mod p-1
+	putBits(constants[wordLen:], g.NewInt(2).Bits(), wordLen)
+
+	err = env.enqueue(stream, kernelReveal, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.get(stream) == nil {
+		t.Error("get should have reported the failed reveal")
+	}
+	if env.get(stream) != nil {
+		t.Error("the error should only be reported once")
+	}
+}
+
+// Chunks shouldn't touch the streams of a pool that's been destroyed
+func TestEmulatedDevice_DestroyedPool(t *testing.T) {
+	g := makeTestGroup2048()
+	b, pool := newEmulatedPool(t, g, kernelPowmOdd)
+	err := pool.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+	_, err = b.ExpChunk(context.Background(), pool, g, x, x, x.DeepCopy())
+	if err != ErrPoolClosed {
+		t.Errorf("expected ErrPoolClosed, got %v", err)
+	}
+}
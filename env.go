@@ -0,0 +1,183 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"math/big"
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+// env.go contains the registry of the widths of numbers that a device can
+// run kernels at. Each width is a row in a table that says where the sizes of
+// the kernels' buffers come from and how kernels are run, and tableEnv
+// implements gpumathsEnv for all of them, so adding a width doesn't take any
+// more methods.
+
+// envWidth describes one width of numbers that a device runs kernels at
+type envWidth struct {
+	bitLen int
+	// sizes returns how many bytes a kernel's constants, each slot's inputs
+	// and each slot's outputs take up at this width
+	sizes func(k kernel) (constants, inputs, outputs int)
+	// enqueue uploads numSlots slots from the stream's buffer, runs the
+	// kernel on them and starts downloading the results. env is the env for
+	// this width, for finding the regions of the buffer.
+	enqueue func(env gpumathsEnv, stream Stream, k kernel, numSlots int) error
+	// get blocks on the stream's download and returns any errors
+	get func(stream Stream) error
+}
+
+// tableEnv is the gpumathsEnv for one row of an envRegistry. The sizes of
+// the kernels' buffers are looked up when the env is first chosen.
+type tableEnv struct {
+	width    envWidth
+	once     sync.Once
+	sizeData sizeData
+	// Why each kernel's sizes couldn't be looked up, or nil if they were
+	kernelErrs [numKernels]error
+}
+
+// envRegistry holds the widths a device can run kernels at, narrowest first
+type envRegistry []*tableEnv
+
+// newEnvRegistry makes a registry out of a table of widths, which can be in
+// any order. Widths must be a whole number of words, and can't be repeated.
+func newEnvRegistry(widths ...envWidth) envRegistry {
+	wordBits := int(unsafe.Sizeof(big.Word(0))) * 8
+	r := make(envRegistry, 0, len(widths))
+	for _, w := range widths {
+		if w.bitLen <= 0 || w.bitLen%wordBits != 0 {
+			panic(fmt.Sprintf("a %v bit env isn't a whole number of words", w.bitLen))
+		}
+		r = append(r, &tableEnv{width: w})
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].width.bitLen < r[j].width.bitLen
+	})
+	for i := 1; i < len(r); i++ {
+		if r[i].width.bitLen == r[i-1].width.bitLen {
+			panic(fmt.Sprintf("there's more than one %v bit env", r[i].width.bitLen))
+		}
+	}
+	return r
+}
+
+// choose returns the narrowest env that's wide enough for a prime of bitLen
+// bits. It returns ErrPrimeTooLarge if none of them are.
+func (r envRegistry) choose(bitLen int) (*tableEnv, error) {
+	i := sort.Search(len(r), func(i int) bool {
+		return r[i].width.bitLen >= bitLen
+	})
+	if i == len(r) {
+		return nil, errors.Wrapf(ErrPrimeTooLarge, "%v bit prime, but the envs have %v bits",
+			bitLen, r.bitLens())
+	}
+	r[i].populateSizeData()
+	return r[i], nil
+}
+
+// bitLens lists the registry's widths, narrowest first
+func (r envRegistry) bitLens() []int {
+	result := make([]int, len(r))
+	for i, env := range r {
+		result[i] = env.width.bitLen
+	}
+	return result
+}
+
+// populateSizeData looks up the sizes of every kernel's buffers. A kernel
+// whose sizes can't be found is recorded in kernelErrs, and doesn't stop the
+// other kernels from being used.
+func (e *tableEnv) populateSizeData() {
+	e.once.Do(func() {
+		for k := kernel(0); k < numKernels; k++ {
+			constants, inputs, outputs := e.width.sizes(k)
+			// If a size is zero, the kernel is unknown
+			if constants == 0 || inputs == 0 || outputs == 0 {
+				e.kernelErrs[k] = errors.Wrapf(ErrUnknownKernel,
+					"couldn't find the sizes of kernel %v at %v bits", k, e.width.bitLen)
+				continue
+			}
+			e.sizeData[k].constantsSize = constants
+			e.sizeData[k].inputSize = inputs
+			e.sizeData[k].outputSize = outputs
+			e.sizeData.populateWordSizes(k)
+		}
+	})
+}
+
+// checkKernel returns ErrUnknownKernel if the kernel's sizes couldn't be
+// found at this width
+func (e *tableEnv) checkKernel(k kernel) error {
+	if k < 0 || k >= numKernels {
+		return errors.Wrapf(ErrUnknownKernel, "kernel %v", k)
+	}
+	return e.kernelErrs[k]
+}
+
+func (e *tableEnv) enqueue(stream Stream, whichToRun kernel, numSlots int) error {
+	return e.width.enqueue(e, stream, whichToRun, numSlots)
+}
+func (e *tableEnv) get(stream Stream) error {
+	return e.width.get(stream)
+}
+
+func (e *tableEnv) getBitLen() int {
+	return e.width.bitLen
+}
+func (e *tableEnv) getByteLen() int {
+	return e.width.bitLen / 8
+}
+func (e *tableEnv) getWordLen() int {
+	return e.getByteLen() / int(unsafe.Sizeof(big.Word(0)))
+}
+
+// Returns size in bytes
+func (e *tableEnv) getConstantsSize(k kernel) int {
+	return e.sizeData[k].constantsSize
+}
+func (e *tableEnv) getInputSize(k kernel) int {
+	return e.sizeData[k].inputSize
+}
+func (e *tableEnv) getOutputSize(k kernel) int {
+	return e.sizeData[k].outputSize
+}
+
+// Returns size in words
+func (e *tableEnv) getConstantsSizeWords(k kernel) int {
+	return e.sizeData[k].constantsSizeWords
+}
+func (e *tableEnv) getInputSizeWords(k kernel) int {
+	return e.sizeData[k].inputSizeWords
+}
+func (e *tableEnv) getOutputSizeWords(k kernel) int {
+	return e.sizeData[k].outputSizeWords
+}
+
+// Helper functions for sizing
+// Get the number of slots for an operation
+func (e *tableEnv) maxSlots(memSize int, op kernel) int {
+	if e.checkKernel(op) != nil {
+		return 0
+	}
+	memForSlots := memSize - e.getConstantsSize(op)
+	if memForSlots < 0 {
+		return 0
+	}
+	return memForSlots / (e.getInputSize(op) + e.getOutputSize(op))
+}
+
+func (e *tableEnv) streamSizeContaining(numItems int, k kernel) int {
+	return e.getInputSize(k)*numItems +
+		e.getOutputSize(k)*numItems +
+		e.getConstantsSize(k)
+}
@@ -0,0 +1,133 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"github.com/pkg/errors"
+	"reflect"
+	"testing"
+)
+
+// The narrowest env that fits the prime should be chosen
+func TestEnvRegistry_Choose(t *testing.T) {
+	// Out of order, to check that the registry sorts them
+	r := newEnvRegistry(emulatedWidth(4096), emulatedWidth(1024), emulatedWidth(3200),
+		emulatedWidth(2048), emulatedWidth(3072))
+	if !reflect.DeepEqual(r.bitLens(), []int{1024, 2048, 3072, 3200, 4096}) {
+		t.Errorf("widths should be sorted, got %v", r.bitLens())
+	}
+	for _, c := range []struct{ primeLen, envLen int }{
+		{1, 1024},
+		{1024, 1024},
+		{1025, 2048},
+		{2048, 2048},
+		{3000, 3072},
+		{3073, 3200},
+		{3201, 4096},
+		{4096, 4096},
+	} {
+		env, err := r.choose(c.primeLen)
+		if err != nil || env.getBitLen() != c.envLen {
+			t.Errorf("a %v bit prime should get the %v bit env, got %v, %v", c.primeLen, c.envLen, env, err)
+		}
+	}
+	_, err := r.choose(4097)
+	if !errors.Is(err, ErrPrimeTooLarge) {
+		t.Errorf("no env should fit a 4097 bit prime, got %v", err)
+	}
+}
+
+func TestNewEnvRegistry_Invalid(t *testing.T) {
+	for name, widths := range map[string][]envWidth{
+		"repeated":       {emulatedWidth(2048), emulatedWidth(1024), emulatedWidth(2048)},
+		"partial word":   {emulatedWidth(2047)},
+		"zero bit width": {emulatedWidth(0)},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("a registry with a %v width should panic", name)
+				}
+			}()
+			newEnvRegistry(widths...)
+		}()
+	}
+}
+
+// Sizes should only be looked up once, when the env is first chosen
+func TestTableEnv_Sizes(t *testing.T) {
+	numLookups := 0
+	width := emulatedWidth(2048)
+	sizes := width.sizes
+	width.sizes = func(k kernel) (int, int, int) {
+		numLookups++
+		return sizes(k)
+	}
+	r := newEnvRegistry(width)
+	if numLookups != 0 {
+		t.Error("sizes shouldn't be looked up before the env is chosen")
+	}
+	for i := 0; i < 3; i++ {
+		env, err := r.choose(2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k := kernel(0); k < numKernels; k++ {
+			layout := kernelLayouts[k]
+			if env.getConstantsSize(k) != layout.constants*256 ||
+				env.getInputSizeWords(k) != layout.inputs*env.getWordLen() ||
+				env.getOutputSize(k) != layout.outputs*256 {
+				t.Errorf("wrong sizes for kernel %v", k)
+			}
+		}
+	}
+	if numLookups != int(numKernels) {
+		t.Errorf("each kernel's sizes should be looked up once, but there were %v lookups", numLookups)
+	}
+}
+
+// A kernel without sizes can't be used, instead of panicking, but the other
+// kernels at its width still can
+func TestTableEnv_UnknownKernel(t *testing.T) {
+	width := emulatedWidth(2048)
+	sizes := width.sizes
+	width.sizes = func(k kernel) (int, int, int) {
+		if k == kernelMul3 {
+			return 0, 0, 0
+		}
+		return sizes(k)
+	}
+	r := newEnvRegistry(width)
+	for i := 0; i < 2; i++ {
+		env, err := r.choose(2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = env.checkKernel(kernelMul3)
+		if !errors.Is(err, ErrUnknownKernel) {
+			t.Errorf("expected ErrUnknownKernel, got %v", err)
+		}
+		if env.maxSlots(1<<16, kernelMul3) != 0 {
+			t.Error("no slots of a kernel without sizes should fit")
+		}
+		err = env.checkKernel(kernelMul2)
+		if err != nil || env.maxSlots(1<<16, kernelMul2) == 0 {
+			t.Errorf("the other kernels should still be usable, got %v", err)
+		}
+	}
+}
+
+// The emulated device should be able to run kernels at every width it has
+func TestEmulatedDevice_Widths(t *testing.T) {
+	for _, bitLen := range []int{1024, 1536, 2048, 3072, 3200, 4096, 6144, 8192} {
+		env := mustChooseEnv(t, emulatedDevice{}, bitLen)
+		if env.getBitLen() != bitLen {
+			t.Errorf("expected the %v bit env, got %v bits", bitLen, env.getBitLen())
+		}
+	}
+}
@@ -0,0 +1,47 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"github.com/pkg/errors"
+)
+
+// errors.go contains the errors that callers can check for. Errors are
+// returned wrapped with the details of what went wrong, so compare them with
+// errors.Is rather than ==.
+
+// ErrNoGPU is returned when the GPU backend is selected in a build that
+// doesn't support CUDA
+var ErrNoGPU = errors.New(NoGpuErrStr)
+
+// ErrPrimeTooLarge is returned when a group's prime is wider than any of the
+// widths that the device can run kernels at
+var ErrPrimeTooLarge = errors.New("prime is too big for any available gpumaths environment")
+
+// ErrUnknownKernel is returned when a kernel isn't one the device knows
+// how to lay out or run
+var ErrUnknownKernel = errors.New("unknown kernel")
+
+// ErrBufferLengthMismatch is returned when the buffers passed to a chunk
+// function don't all have the same number of slots
+var ErrBufferLengthMismatch = errors.New("buffer lengths don't match")
+
+// ErrPoolClosed is returned when a stream is asked for from a pool that's
+// being destroyed, or already has been
+var ErrPoolClosed = errors.New("stream pool is closed")
+
+// checkLengths returns ErrBufferLengthMismatch if the lengths of an op's
+// buffers aren't all the same
+func checkLengths(op string, lengths ...int) error {
+	for _, length := range lengths[1:] {
+		if length != lengths[0] {
+			return errors.Wrapf(ErrBufferLengthMismatch, "%v got buffers with lengths %v", op, lengths)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"gitlab.com/elixxir/crypto/cyclic"
+	"gitlab.com/xx_network/crypto/large"
+	"strings"
+	"testing"
+)
+
+// A prime that's wider than the widest emulated env
+func makeTestGroupTooLarge() *cyclic.Group {
+	p := large.NewIntFromString("1"+strings.Repeat("0", 2100)+"1", 16)
+	return cyclic.NewGroup(p, large.NewInt(2))
+}
+
+// A group whose prime doesn't fit any env should get an error from every
+// chunk function, rather than crashing
+func TestGpuBackend_PrimeTooLarge(t *testing.T) {
+	b := gpuBackend{dev: emulatedDevice{}}
+	pool, err := b.NewStreamPool(1, 1<<16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := makeTestGroupTooLarge()
+	x := g.NewIntBuffer(2, g.NewInt(2))
+	ctx := context.Background()
+
+	_, err = b.ExpChunk(ctx, pool, g, x, x, x.DeepCopy())
+	checkErrorIs(t, "ExpChunk", err, ErrPrimeTooLarge)
+	err = b.ElGamalChunk(ctx, pool, g, x, x, g.NewInt(2), x.DeepCopy(), x.DeepCopy())
+	checkErrorIs(t, "ElGamalChunk", err, ErrPrimeTooLarge)
+	err = b.RevealChunk(ctx, pool, g, g.NewInt(3), x, x.DeepCopy())
+	checkErrorIs(t, "RevealChunk", err, ErrPrimeTooLarge)
+	err = b.Mul2Chunk(ctx, pool, g, x, x, x.DeepCopy())
+	checkErrorIs(t, "Mul2Chunk", err, ErrPrimeTooLarge)
+	err = b.Mul3Chunk(ctx, pool, g, x, x, x, x.DeepCopy())
+	checkErrorIs(t, "Mul3Chunk", err, ErrPrimeTooLarge)
+
+	_, err = b.NewStreamPoolForWorkload(1, Workload{BitLen: g.GetP().BitLen(),
+		Ops: []Op{OpExp}, BatchSize: 1})
+	checkErrorIs(t, "NewStreamPoolForWorkload", err, ErrPrimeTooLarge)
+}
+
+// Buffers of different lengths are rejected by both backends before any
+// slots are computed
+func TestChunks_BufferLengthMismatch(t *testing.T) {
+	g := makeTestGroup2048()
+	gpu, pool := newEmulatedPool(t, g, kernelElgamal)
+	short := initRandomIntBuffer(g, 2, 1, 0)
+	long := initRandomIntBuffer(g, 3, 2, 0)
+	ctx := context.Background()
+
+	for _, b := range []Backend{cpuBackend{}, gpu} {
+		p := pool
+		if _, ok := b.(cpuBackend); ok {
+			p = nil
+		}
+		_, err := b.ExpChunk(ctx, p, g, short, long, long.DeepCopy())
+		checkErrorIs(t, b.Name()+" ExpChunk", err, ErrBufferLengthMismatch)
+		err = b.ElGamalChunk(ctx, p, g, long, long, g.NewInt(2), long.DeepCopy(), short.DeepCopy())
+		checkErrorIs(t, b.Name()+" ElGamalChunk", err, ErrBufferLengthMismatch)
+		err = b.RevealChunk(ctx, p, g, g.NewInt(3), long, short.DeepCopy())
+		checkErrorIs(t, b.Name()+" RevealChunk", err, ErrBufferLengthMismatch)
+		err = b.Mul2Chunk(ctx, p, g, long, short, long.DeepCopy())
+		checkErrorIs(t, b.Name()+" Mul2Chunk", err, ErrBufferLengthMismatch)
+		err = b.Mul2Chunk(ctx, p, g, long, make(IntSlice, 3), make(IntSlice, 2))
+		checkErrorIs(t, b.Name()+" Mul2Chunk with slices", err, ErrBufferLengthMismatch)
+		err = b.Mul3Chunk(ctx, p, g, long, long, short, long.DeepCopy())
+		checkErrorIs(t, b.Name()+" Mul3Chunk", err, ErrBufferLengthMismatch)
+	}
+}
+
+// Taking a stream from a destroyed pool should be recognisable by callers
+func TestStreamPool_Closed(t *testing.T) {
+	pool, err := cpuBackend{}.NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pool.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := makeTestGroup2048()
+	x := g.NewIntBuffer(1, g.NewInt(2))
+	err = cpuBackend{}.Mul2Chunk(context.Background(), pool, g, x, x, x.DeepCopy())
+	checkErrorIs(t, "Mul2Chunk", err, ErrPoolClosed)
+}
+
+func checkErrorIs(t *testing.T, what string, err, target error) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Errorf("%v: expected %q, got %v", what, target, err)
+	}
+}
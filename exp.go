@@ -7,16 +7,82 @@
 
 package gpumaths
 
-import "gitlab.com/elixxir/crypto/cyclic"
+import (
+	"context"
+	"gitlab.com/elixxir/crypto/cyclic"
+)
 
 // exp.go contains the input, results, and other types for running the
-// exp operation against the GPU. The actual GPU call is in exp_gpu.go
-// and is marked to require `-tags cuda` in your build.
+// exp operation on the active backend. The actual GPU call is in exp_gpu.go
+// and runs on CUDA when built with `-tags gpu`. The CPU version is in
+// exp_cpu.go.
 
 // ExpChunkPrototype Implement cryptop interface for ExpChunk
 type ExpChunkPrototype func(p *StreamPool, g *cyclic.Group,
 	x, y, z *cyclic.IntBuffer) (*cyclic.IntBuffer, error)
 
+// ExpChunk performs exponentiation for two operands and places the result in z
+// (which is also returned), so z[i] = x[i]**y[i] mod p.
+// It runs on the active backend. x, y and z must have the same length, or
+// ErrBufferLengthMismatch is returned.
+var ExpChunk ExpChunkPrototype = func(p *StreamPool, g *cyclic.Group,
+	x, y, z *cyclic.IntBuffer) (*cyclic.IntBuffer, error) {
+	_, err := ExpChunkContext(context.Background(), p, g, x, y, z)
+	if err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// ExpChunkContext is ExpChunk, but it takes any Ints, and it stops between
+// kernels (or slots, on the CPU) once ctx is done, and returns ctx.Err().
+// Slots that hadn't been computed yet are left as they were.
+func ExpChunkContext(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x, y, z Ints) (Ints, error) {
+	return ActiveBackend().ExpChunk(ctx, p, g, x, y, z)
+}
+
+// ExpChunkAsync is ExpChunkContext, but it runs in the background.
+// The outputs can be used once the returned handle is done.
+func ExpChunkAsync(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x, y, z Ints) *ChunkHandle {
+	b := ActiveBackend()
+	return goChunk(func() error {
+		_, err := b.ExpChunk(ctx, p, g, x, y, z)
+		return err
+	})
+}
+
+// ExpSlicePrototype is ExpChunkPrototype for slices of cyclic ints
+type ExpSlicePrototype func(p *StreamPool, g *cyclic.Group,
+	x, y, z []*cyclic.Int) ([]*cyclic.Int, error)
+
+// ExpSlice is ExpChunk, but it takes slices of cyclic ints instead of int
+// buffers, so z[i] = x[i]**y[i] mod p. z is also returned.
+var ExpSlice ExpSlicePrototype = func(p *StreamPool, g *cyclic.Group,
+	x, y, z []*cyclic.Int) ([]*cyclic.Int, error) {
+	return ExpSliceContext(context.Background(), p, g, x, y, z)
+}
+
+// ExpSliceContext is ExpSlice, but it stops between kernels (or slots, on the
+// CPU) once ctx is done, and returns ctx.Err(). Slots that hadn't been
+// computed yet are left as they were.
+func ExpSliceContext(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x, y, z []*cyclic.Int) ([]*cyclic.Int, error) {
+	_, err := ExpChunkContext(ctx, p, g, IntSlice(x), IntSlice(y), IntSlice(z))
+	if err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// ExpSliceAsync is ExpSliceContext, but it runs in the background.
+// The outputs can be used once the returned handle is done.
+func ExpSliceAsync(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x, y, z []*cyclic.Int) *ChunkHandle {
+	return ExpChunkAsync(ctx, p, g, IntSlice(x), IntSlice(y), IntSlice(z))
+}
+
 // GetName returns name of op (ExpChunk)
 func (ExpChunkPrototype) GetName() string {
 	return "ExpChunk"
@@ -26,3 +92,13 @@ func (ExpChunkPrototype) GetName() string {
 func (ExpChunkPrototype) GetInputSize() uint32 {
 	return 64
 }
+
+// GetName returns name of op (ExpSlice)
+func (ExpSlicePrototype) GetName() string {
+	return "ExpSlice"
+}
+
+// GetInputSize is the size of each chunk for this op
+func (ExpSlicePrototype) GetInputSize() uint32 {
+	return 64
+}
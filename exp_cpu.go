@@ -5,17 +5,39 @@
 // LICENSE file.                                                              //
 ////////////////////////////////////////////////////////////////////////////////
 
-//+build !linux !gpu
-
 package gpumaths
 
 import (
-	"errors"
+	"context"
 	"gitlab.com/elixxir/crypto/cyclic"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
 )
 
-// ExpChunk is stubbed unless GPU is present.
-var ExpChunk ExpChunkPrototype = func(p *StreamPool, g *cyclic.Group,
-	x, y, z *cyclic.IntBuffer) (*cyclic.IntBuffer, error) {
-	return z, errors.New(NoGpuErrStr)
+// ExpChunk performs exponentiation for two operands on the CPU and places the
+// result in z (which is also returned), so z[i] = x[i]**y[i] mod p.
+// Slots are computed in parallel across all available cores, while holding
+// one of the pool's streams. The pool may be nil.
+func (cpuBackend) ExpChunk(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x, y, z Ints) (Ints, error) {
+	err := checkLengths("ExpChunk", x.Len(), y.Len(), z.Len())
+	if err != nil {
+		return nil, err
+	}
+	err = p.validate("ExpChunk", g, elements("x", x), exponents("y", y))
+	if err != nil {
+		return nil, err
+	}
+	numSlots := uint32(z.Len())
+	release, err := p.acquire(ctx, "ExpChunk", numSlots)
+	if err != nil {
+		return nil, err
+	}
+	err = release(forEachSlotContext(ctx, numSlots, func(i uint32) {
+		cryptops.Exp(g, x.Get(i), y.Get(i), z.Get(i))
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return z, nil
 }
@@ -0,0 +1,49 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
+	"testing"
+)
+
+// CPU ExpChunk results should match cryptops.Exp in every slot
+func TestCpuBackend_ExpChunk(t *testing.T) {
+	const numSlots = 37
+	g := makeTestGroup2048()
+	x := initRandomIntBuffer(g, numSlots, 42, 0)
+	y := initRandomIntBuffer(g, numSlots, 43, 256/8)
+	z := g.NewIntBuffer(numSlots, g.NewInt(1))
+
+	result, err := cpuBackend{}.ExpChunk(context.Background(), nil, g, x, y, z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != z {
+		t.Error("ExpChunk should return the result buffer that was passed in")
+	}
+
+	for i := uint32(0); i < numSlots; i++ {
+		expected := cryptops.Exp(g, x.Get(i), y.Get(i), g.NewInt(1))
+		if expected.Cmp(z.Get(i)) != 0 {
+			t.Errorf("Go results (%+v) didn't match ExpChunk results (%+v) in slot %v",
+				expected.Text(16), z.Get(i).Text(16), i)
+		}
+	}
+}
+
+// An empty chunk shouldn't do anything or fail
+func TestCpuBackend_ExpChunk_Empty(t *testing.T) {
+	g := makeTestGroup2048()
+	z := g.NewIntBuffer(0, g.NewInt(1))
+	_, err := cpuBackend{}.ExpChunk(context.Background(), nil, g, z, z, z)
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -5,115 +5,99 @@
 // LICENSE file.                                                              //
 ////////////////////////////////////////////////////////////////////////////////
 
-//+build linux,gpu
-
 package gpumaths
 
-/*#cgo LDFLAGS: -Llib -lpowmosm75 -Wl,-rpath -Wl,./lib:/opt/xxnetwork/lib
-#cgo CFLAGS: -I./cgbnBindings/powm -I/opt/xxnetwork/include
-#include <powm_odd_export.h>
-*/
-import "C"
 import (
-	jww "github.com/spf13/jwalterweatherman"
+	"context"
 	"gitlab.com/elixxir/crypto/cyclic"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
 )
 
-// exp_gpu.go contains the CUDA ops for the exp operation. exp(...)
-// performs the actual call into the library and ExpChunk implements
-// the streaming interface function called by the server implementation.
-
-const (
-	kernelPowmOdd = C.KERNEL_POWM_ODD
-)
+// exp_gpu.go contains the gpu ops for the exp operation. putExp(...) and
+// importExp(...) lay out the stream's buffer for the library's kernel, and
+// ExpChunk implements the streaming interface function called by the server
+// implementation.
 
 // ExpChunk Performs exponentiation for two operands and place the result in z
 // (which is also returned)
 // Using this function doesn't allow you to do other things while waiting
 // on the kernel to finish
-var ExpChunk ExpChunkPrototype = func(p *StreamPool, g *cyclic.Group,
-	x, y, z *cyclic.IntBuffer) (*cyclic.IntBuffer, error) {
-	// Populate exp inputs
-	numSlots := uint32(z.Len())
-
-	// Run kernel on the inputs, simply using smaller chunks if passed
-	// chunk size exceeds buffer space in stream
-	stream := p.TakeStream()
-	defer p.ReturnStream(stream)
-	env := chooseEnv(g)
-	maxSlotsExp := uint32(env.maxSlots(len(stream.cpuData), kernelPowmOdd))
-	if numSlots > maxSlotsExp {
-		jww.WARN.Printf("Running multiple kernels for ExpChunk. Performance may be degraded")
+func (b gpuBackend) ExpChunk(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x, y, z Ints) (Ints, error) {
+	err := checkLengths("ExpChunk", x.Len(), y.Len(), z.Len())
+	if err != nil {
+		return nil, err
 	}
-	for i := uint32(0); i < numSlots; i += maxSlotsExp {
-		sliceEnd := i
-		// Don't slice beyond the end of the input slice
-		if i+maxSlotsExp <= numSlots {
-			sliceEnd += maxSlotsExp
-		} else {
-			sliceEnd = numSlots
-		}
-		err := <-exp(g, x.GetSubBuffer(i, sliceEnd), y.GetSubBuffer(i, sliceEnd), z.GetSubBuffer(i, sliceEnd), env, stream)
-		if err != nil {
-			return nil, err
-		}
+	err = p.validate("ExpChunk", g, elements("x", x), exponents("y", y))
+	if err != nil {
+		return nil, err
+	}
+	err = b.runChunk(ctx, p, g, gpuOp{
+		name:     "ExpChunk",
+		kernel:   kernelPowmOdd,
+		numSlots: uint32(z.Len()),
+		put: func(env gpumathsEnv, stream Stream, start, end uint32) {
+			putExp(g, subInts(x, start, end), subInts(y, start, end), env, stream)
+		},
+		importResults: func(env gpumathsEnv, stream Stream, start, end uint32) {
+			importExp(g, subInts(z, start, end), env, stream)
+		},
+		cpu: func(start, end uint32) error {
+			_, err := cpuBackend{}.ExpChunk(ctx, nil, g, subInts(x, start, end),
+				subInts(y, start, end), subInts(z, start, end))
+			return err
+		},
+		check: func(slot uint32) func() bool {
+			xSlot, ySlot := x.Get(slot).DeepCopy(), y.Get(slot).DeepCopy()
+			return func() bool {
+				return cryptops.Exp(g, xSlot, ySlot, g.NewInt(1)).Cmp(z.Get(slot)) == 0
+			}
+		},
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// If there were no errors, we return z
 	return z, nil
 }
 
-func exp(g *cyclic.Group, x, y, result *cyclic.IntBuffer, env gpumathsEnv, stream Stream) chan error {
-	// Return the result later, when the GPU job finishes
-	resultChan := make(chan error, 1)
-	go func() {
-		// Arrange memory into stream buffers
-		numSlots := uint32(x.Len())
-
-		// TODO clean this up by implementing the
-		// arrangement/dearrangement with reader/writer interfaces
-		// or smth
-		constants := stream.getCpuConstantsWords(env, kernelPowmOdd)
-		offset := 0
-		bnLengthWords := env.getWordLen()
-		putBits(constants, g.GetP().Bits(), bnLengthWords)
-
-		inputs := stream.getCpuInputsWords(env, kernelPowmOdd, int(numSlots))
-		offset = 0
-		for i := uint32(0); i < numSlots; i++ {
-			putBits(inputs[offset:offset+bnLengthWords], x.Get(i).Bits(), bnLengthWords)
-			offset += bnLengthWords
-			putBits(inputs[offset:offset+bnLengthWords], y.Get(i).Bits(), bnLengthWords)
-			offset += bnLengthWords
-		}
-
-		// Upload, run, wait for download
-		err := env.enqueue(stream, kernelPowmOdd, int(numSlots))
-		if err != nil {
-			resultChan <- err
-			return
-		}
-
-		// Results will be stored in this buffer
-		// This intermediary copy is necessary because the byte order needs to be reversed
-		results := stream.getCpuOutputsWords(env, kernelPowmOdd, int(numSlots))
-
-		// Wait on things to finish with Cuda
-		err = get(stream)
-		if err != nil {
-			resultChan <- err
-			return
-		}
-
-		// Everything is OK, so let's go ahead and import the results
-		offset = 0
-		for i := uint32(0); i < numSlots; i++ {
-			end := offset + bnLengthWords
-			g.OverwriteBits(result.Get(i), results[offset:end])
-			offset += bnLengthWords
-		}
+// putExp arranges the prime and each slot's base and exponent in the
+// stream's buffer
+func putExp(g *cyclic.Group, x, y Ints, env gpumathsEnv, stream Stream) {
+	// Arrange memory into stream buffers
+	numSlots := uint32(x.Len())
+
+	// TODO clean this up by implementing the
+	// arrangement/dearrangement with reader/writer interfaces
+	// or smth
+	constants := stream.getCpuConstantsWords(env, kernelPowmOdd)
+	offset := 0
+	bnLengthWords := env.getWordLen()
+	putBits(constants, g.GetP().Bits(), bnLengthWords)
+
+	inputs := stream.getCpuInputsWords(env, kernelPowmOdd, int(numSlots))
+	offset = 0
+	for i := uint32(0); i < numSlots; i++ {
+		putBits(inputs[offset:offset+bnLengthWords], x.Get(i).Bits(), bnLengthWords)
+		offset += bnLengthWords
+		putBits(inputs[offset:offset+bnLengthWords], y.Get(i).Bits(), bnLengthWords)
+		offset += bnLengthWords
+	}
+}
 
-		resultChan <- nil
-	}()
-	return resultChan
+// importExp copies each slot's power out of the stream's buffer
+func importExp(g *cyclic.Group, result Ints, env gpumathsEnv, stream Stream) {
+	numSlots := uint32(result.Len())
+	bnLengthWords := env.getWordLen()
+	// Results will be stored in this buffer
+	// This intermediary copy is necessary because the byte order needs to be reversed
+	results := stream.getCpuOutputsWords(env, kernelPowmOdd, int(numSlots))
+
+	offset := 0
+	for i := uint32(0); i < numSlots; i++ {
+		end := offset + bnLengthWords
+		g.OverwriteBits(result.Get(i), results[offset:end])
+		offset += bnLengthWords
+	}
 }
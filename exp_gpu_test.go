@@ -10,8 +10,10 @@
 package gpumaths
 
 import (
+	"context"
 	"gitlab.com/elixxir/gpumathsgo/cryptops"
 	"gitlab.com/elixxir/crypto/cyclic"
+	"sync"
 	"testing"
 )
 
@@ -144,13 +146,14 @@ func BenchmarkExpGPU_32768(b *testing.B) {
 
 func BenchmarkPowmCUDA4096_4096(b *testing.B) {
 	g := makeTestGroup4096()
-	env := &gpumathsEnv4096
+	env := mustChooseEnv(b, cudaDevice{}, 4096)
 	numSlots := uint32(b.N)
 	Base := initRandomIntBuffer(g, numSlots, 42, 0)
 	Exponent := initRandomIntBuffer(g, numSlots, 42, 0)
 	Results := g.NewIntBuffer(numSlots, g.NewInt(1))
 
-	stream, err := createStreams(1, env.streamSizeContaining(int(numSlots),
+	gpu := gpuBackend{dev: cudaDevice{}}
+	streamPool, err := gpu.NewStreamPool(1, env.streamSizeContaining(int(numSlots),
 		kernelPowmOdd))
 	if err != nil {
 		b.Fatal(err)
@@ -160,8 +163,7 @@ func BenchmarkPowmCUDA4096_4096(b *testing.B) {
 	// It might be possible to run another benchmark that does two or more
 	// chunks instead, which could be faster if the call could be made
 	// asynchronous (which should be possible)
-	errors := exp(g, Base, Exponent, Results, env, stream[0])
-	err = <-errors
+	_, err = gpu.ExpChunk(context.Background(), streamPool, g, Base, Exponent, Results)
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -177,11 +179,15 @@ func BenchmarkPowmCUDA4096_4096(b *testing.B) {
 func BenchmarkPowmCUDA4096_256(b *testing.B) {
 
 	g := makeTestGroup4096()
-	env := &gpumathsEnv4096
+	env := mustChooseEnv(b, cudaDevice{}, 4096)
 
 	numSlots := uint32(b.N)
-	streams, err := createStreams(1, env.streamSizeContaining(int(numSlots),
+	gpu := gpuBackend{dev: cudaDevice{}}
+	streamPool, err := gpu.NewStreamPool(1, env.streamSizeContaining(int(numSlots),
 		kernelPowmOdd))
+	if err != nil {
+		b.Fatal(err)
+	}
 
 	Base := initRandomIntBuffer(g, numSlots, 42, 0)
 	Exponent := initRandomIntBuffer(g, numSlots, 42, 256/8)
@@ -191,8 +197,7 @@ func BenchmarkPowmCUDA4096_256(b *testing.B) {
 	// It might be possible to run another benchmark that does two or more
 	// chunks instead, which could be faster if the call could be made
 	// asynchronous (which should be possible)
-	resultChan := exp(g, Base, Exponent, Results, env, streams[0])
-	err = <-resultChan
+	_, err = gpu.ExpChunk(context.Background(), streamPool, g, Base, Exponent, Results)
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -211,17 +216,19 @@ func BenchmarkPowmCUDA2048_256_streams(b *testing.B) {
 	const yBitLen = 256
 	const yByteLen = yBitLen / 8
 	g := makeTestGroup2048()
-	env := &gpumathsEnv2048
+	env := mustChooseEnv(b, cudaDevice{}, 2048)
 	// Use two streams with 32k items per kernel launch
 	numItems := 32768
 
-	streamPool, err := NewStreamPool(2, env.streamSizeContaining(numItems,
+	gpu := gpuBackend{dev: cudaDevice{}}
+	streamPool, err := gpu.NewStreamPool(2, env.streamSizeContaining(numItems,
 		kernelPowmOdd))
 	if err != nil {
 		b.Fatal(err)
 	}
 	// Using prng because the cryptographically secure RNG used by the
 	// group is too slow to feed the GPU
+	var wg sync.WaitGroup
 	b.ResetTimer()
 	remainingItems := b.N
 	for i := 0; i < b.N; i += numItems {
@@ -235,19 +242,17 @@ func BenchmarkPowmCUDA2048_256_streams(b *testing.B) {
 		base := initRandomIntBuffer(g, uint32(numItemsToUpload), 42, 0)
 		exponent := initRandomIntBuffer(g, uint32(numItemsToUpload), 42, yByteLen)
 		results := g.NewIntBuffer(uint32(numItemsToUpload), g.NewInt(1))
-		stream := streamPool.TakeStream()
-		errChan := exp(g, base, exponent, results, env, stream)
+		wg.Add(1)
 		go func() {
-			err := <-errChan
-			streamPool.ReturnStream(stream)
+			defer wg.Done()
+			_, err := gpu.ExpChunk(context.Background(), streamPool, g, base, exponent, results)
 			if err != nil {
-				b.Fatal(err)
+				b.Error(err)
 			}
 		}()
 	}
-	// Empty the pool to make sure results have all been downloaded
-	streamPool.TakeStream()
-	streamPool.TakeStream()
+	// Wait for the results to all be downloaded
+	wg.Wait()
 	b.StopTimer()
 	err = streamPool.Destroy()
 	if err != nil {
@@ -265,17 +270,19 @@ func BenchmarkPowmCUDA4096_256_streams(b *testing.B) {
 	const yBitLen = 256
 	const yByteLen = yBitLen / 8
 	g := makeTestGroup4096()
-	env := &gpumaths4096{}
+	env := mustChooseEnv(b, cudaDevice{}, 4096)
 	// Use two streams with 32k items per kernel launch
 	numItems := 32768
 
-	streamPool, err := NewStreamPool(2, env.streamSizeContaining(numItems,
+	gpu := gpuBackend{dev: cudaDevice{}}
+	streamPool, err := gpu.NewStreamPool(2, env.streamSizeContaining(numItems,
 		kernelPowmOdd))
 	if err != nil {
 		b.Fatal(err)
 	}
 	// Using prng because the cryptographically secure RNG used by the
 	// group is too slow to feed the GPU
+	var wg sync.WaitGroup
 	b.ResetTimer()
 	remainingItems := b.N
 	for i := 0; i < b.N; i += numItems {
@@ -289,19 +296,17 @@ func BenchmarkPowmCUDA4096_256_streams(b *testing.B) {
 		base := initRandomIntBuffer(g, uint32(numItemsToUpload), 42, 0)
 		exponent := initRandomIntBuffer(g, uint32(numItemsToUpload), 42, yByteLen)
 		results := g.NewIntBuffer(uint32(numItemsToUpload), g.NewInt(1))
-		stream := streamPool.TakeStream()
-		errChan := exp(g, base, exponent, results, env, stream)
+		wg.Add(1)
 		go func() {
-			err := <-errChan
-			streamPool.ReturnStream(stream)
+			defer wg.Done()
+			_, err := gpu.ExpChunk(context.Background(), streamPool, g, base, exponent, results)
 			if err != nil {
-				b.Fatal(err)
+				b.Error(err)
 			}
 		}()
 	}
-	// Empty the pool to make sure results have all been downloaded
-	streamPool.TakeStream()
-	streamPool.TakeStream()
+	// Wait for the results to all be downloaded
+	wg.Wait()
 	b.StopTimer()
 	err = streamPool.Destroy()
 	if err != nil {
@@ -10,6 +10,7 @@
 package gpumaths
 
 import (
+	"context"
 	"gitlab.com/elixxir/gpumathsgo/cryptops"
 	"gitlab.com/xx_network/crypto/large"
 	"testing"
@@ -17,7 +18,7 @@ import (
 
 // CUDA powm result should match golang powm result for all slots
 func TestPowm4096(t *testing.T) {
-	env := &gpumathsEnv4096
+	env := mustChooseEnv(t, cudaDevice{}, 4096)
 	const numSlots = 128
 	// Do computations with CUDA first
 	g := makeTestGroup4096()
@@ -30,13 +31,11 @@ func TestPowm4096(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	stream := streamPool.TakeStream()
-	errors := exp(g, Base, Exponent, Result, env, stream)
-	err = <-errors
+	_, err = gpuBackend{dev: cudaDevice{}}.ExpChunk(context.Background(), streamPool, g,
+		Base, Exponent, Result)
 	if err != nil {
 		t.Fatal(err)
 	}
-	streamPool.ReturnStream(stream)
 
 	// Compare to results from the Golang library
 	// z = x**y mod p
@@ -65,7 +64,7 @@ func TestElgamal4096(t *testing.T) {
 	const numSlots = 12
 	// Do computations with CUDA first
 	g := makeTestGroup4096()
-	env := &gpumathsEnv4096
+	env := mustChooseEnv(t, cudaDevice{}, 4096)
 
 	// Build some random inputs for elgamal kernel
 	PublicCypherKey := g.Random(g.NewInt(1))
@@ -79,11 +78,8 @@ func TestElgamal4096(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	stream := streamPool.TakeStream()
-	// I think I want to actually pass a stream to ElGamal...
-	// Is that too explicit/weird?
-	resultChan := elGamal(g, Key, PrivateKey, PublicCypherKey, EcrKey, Cypher, env, stream)
-	err = <-resultChan
+	err = gpuBackend{dev: cudaDevice{}}.ElGamalChunk(context.Background(), streamPool, g,
+		Key, PrivateKey, PublicCypherKey, EcrKey, Cypher)
 	if err != nil {
 		t.Error(err)
 	}
@@ -100,7 +96,6 @@ func TestElgamal4096(t *testing.T) {
 			t.Errorf("cypher didn't match cpu result at index %v", i)
 		}
 	}
-	streamPool.ReturnStream(stream)
 	err = streamPool.Destroy()
 	if err != nil {
 		t.Fatal(err)
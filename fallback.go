@@ -0,0 +1,96 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+// fallback.go contains what a stream pool does when a kernel fails on the
+// GPU partway through a chunk, e.g. because the driver was reset, the device
+// ran out of memory, or CGBN reported an error.
+
+// FallbackPolicy decides what happens when a kernel fails. The zero value
+// returns the kernel's error from the chunk function.
+type FallbackPolicy struct {
+	// Recompute the slots of the failed kernel on the CPU instead of
+	// returning the error
+	UseCPU bool
+	// Quarantine the stream the kernel failed on straight away, instead of
+	// once HealthPolicy.MaxFailures kernels have failed on it. The pool always
+	// keeps at least one stream in rotation, so it can't run out of them
+	MarkUnhealthy bool
+}
+
+// Fallback describes a kernel whose slots were recomputed on the CPU
+type Fallback struct {
+	// Name of the chunk function, e.g. "ExpChunk"
+	Op string
+	// Which stream the kernel failed on
+	StreamID int
+	// The range of slots within the chunk that were recomputed
+	Start, End uint32
+	// What the kernel failed with
+	Err error
+}
+
+// SetFallbackPolicy sets what the pool does when a kernel fails on one of
+// its streams
+func (sm *StreamPool) SetFallbackPolicy(policy FallbackPolicy) {
+	sm.mux.Lock()
+	sm.fallbackPolicy = policy
+	sm.mux.Unlock()
+}
+
+// GetFallbackPolicy returns what the pool does when a kernel fails
+func (sm *StreamPool) GetFallbackPolicy() FallbackPolicy {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	return sm.fallbackPolicy
+}
+
+// NumFallbacks returns how many kernels have been recomputed on the CPU
+func (sm *StreamPool) NumFallbacks() int {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	return sm.numFallbacks
+}
+
+// LastFallback returns the most recent kernel that was recomputed on the CPU,
+// if there has been one
+func (sm *StreamPool) LastFallback() (Fallback, bool) {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	return sm.lastFallback, sm.numFallbacks > 0
+}
+
+// UnhealthyStreams returns how many streams have been taken out of rotation,
+// whether they're quarantined or have failed for good
+func (sm *StreamPool) UnhealthyStreams() int {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	return sm.numUnhealthy
+}
+
+// kernelFailed applies the fallback and health policies to a kernel that
+// failed on the stream. It returns whether the failed slots should be
+// recomputed on the CPU.
+func (sm *StreamPool) kernelFailed(stream Stream, f Fallback) bool {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	policy := sm.fallbackPolicy
+	sm.recordError(f.Op)
+	sm.streamFailed(stream, f)
+	if policy.UseCPU {
+		jww.WARN.Printf("Recomputing slots %v to %v of %v on the CPU: %v",
+			f.Start, f.End, f.Op, f.Err)
+		sm.numFallbacks++
+		sm.lastFallback = f
+	}
+	return policy.UseCPU
+}
@@ -0,0 +1,190 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
+	"sync"
+	"testing"
+)
+
+// onCalls returns a function that's true on the numbered calls, counting
+// from 1
+func onCalls(calls ...int) func() bool {
+	var mux sync.Mutex
+	numCalls := 0
+	return func() bool {
+		mux.Lock()
+		defer mux.Unlock()
+		numCalls++
+		for _, call := range calls {
+			if call == numCalls {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// failCalls fails the numbered calls, counting from 1
+func failCalls(calls ...int) func(Stream) error {
+	on := onCalls(calls...)
+	return func(Stream) error {
+		if on() {
+			return errors.New("injected failure")
+		}
+		return nil
+	}
+}
+
+func failAlways(Stream) error {
+	return errors.New("injected failure")
+}
+
+// Without a policy, a failed kernel fails the whole chunk
+func TestFallback_Off(t *testing.T) {
+	g := makeTestGroup2048()
+	b, pool := newTestPool(t, g, kernelPowmOdd, 1, testHooks{onGet: failCalls(2)})
+	x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+	y := initRandomIntBuffer(g, emulatedNumSlots, 2, 32)
+	z := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+
+	_, err := b.ExpChunk(context.Background(), pool, g, x, y, z)
+	if err == nil {
+		t.Error("ExpChunk should have returned the kernel's error")
+	}
+	if pool.NumFallbacks() != 0 {
+		t.Errorf("nothing should have fallen back, but %v kernels did", pool.NumFallbacks())
+	}
+}
+
+// With UseCPU, the slots of the failed kernel are recomputed on the CPU and
+// the chunk still has the right results
+func TestFallback_UseCPU(t *testing.T) {
+	g := makeTestGroup2048()
+	b, pool := newTestPool(t, g, kernelPowmOdd, 1, testHooks{onGet: failCalls(2)})
+	pool.SetFallbackPolicy(FallbackPolicy{UseCPU: true})
+	x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+	y := initRandomIntBuffer(g, emulatedNumSlots, 2, 32)
+	z := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+
+	_, err := b.ExpChunk(context.Background(), pool, g, x, y, z)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+	for i := uint32(0); i < emulatedNumSlots; i++ {
+		cryptops.Exp(g, x.Get(i), y.Get(i), expected.Get(i))
+	}
+	checkSlots(t, expected, z)
+
+	if pool.NumFallbacks() != 1 {
+		t.Errorf("one kernel should have fallen back, but %v did", pool.NumFallbacks())
+	}
+	f, ok := pool.LastFallback()
+	if !ok {
+		t.Fatal("the fallback wasn't recorded")
+	}
+	if f.Op != "ExpChunk" || f.Start != emulatedSlotsPerStream ||
+		f.End != 2*emulatedSlotsPerStream || f.Err == nil {
+		t.Errorf("the second kernel should have been recorded, but got %+v", f)
+	}
+	if pool.UnhealthyStreams() != 0 {
+		t.Error("the stream shouldn't have been marked unhealthy")
+	}
+}
+
+// ElGamal updates its buffers in place, so the CPU has to start from the
+// inputs that the failed kernel had
+func TestFallback_UseCPU_InPlace(t *testing.T) {
+	g := makeTestGroup2048()
+	b, pool := newTestPool(t, g, kernelElgamal, 1, testHooks{onGet: failCalls(1, 3)})
+	pool.SetFallbackPolicy(FallbackPolicy{UseCPU: true})
+	key := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+	privateKey := initRandomIntBuffer(g, emulatedNumSlots, 2, 32)
+	publicCypherKey := initRandomIntBuffer(g, 1, 3, 0).Get(0)
+	ecrKey := initRandomIntBuffer(g, emulatedNumSlots, 4, 0)
+	cypher := initRandomIntBuffer(g, emulatedNumSlots, 5, 0)
+	expectedEcrKey := ecrKey.DeepCopy()
+	expectedCypher := cypher.DeepCopy()
+
+	err := b.ElGamalChunk(context.Background(), pool, g, key, privateKey, publicCypherKey, ecrKey, cypher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := uint32(0); i < emulatedNumSlots; i++ {
+		cryptops.ElGamal(g, key.Get(i), privateKey.Get(i), publicCypherKey,
+			expectedEcrKey.Get(i), expectedCypher.Get(i))
+	}
+	checkSlots(t, expectedEcrKey, ecrKey)
+	checkSlots(t, expectedCypher, cypher)
+	if pool.NumFallbacks() != 2 {
+		t.Errorf("two kernels should have fallen back, but %v did", pool.NumFallbacks())
+	}
+}
+
+// A stream that a kernel failed on is taken out of rotation, but the pool
+// always keeps one
+func TestFallback_MarkUnhealthy(t *testing.T) {
+	g := makeTestGroup2048()
+	b, pool := newTestPool(t, g, kernelMul2, 2, testHooks{onGet: failAlways})
+	pool.SetFallbackPolicy(FallbackPolicy{UseCPU: true, MarkUnhealthy: true})
+	x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+	y := initRandomIntBuffer(g, emulatedNumSlots, 2, 0)
+	result := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+
+	for i := 0; i < 3; i++ {
+		err := b.Mul2Chunk(context.Background(), pool, g, x, y, result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pool.UnhealthyStreams() != 1 {
+			t.Fatalf("one of the two streams should be unhealthy, but %v are",
+				pool.UnhealthyStreams())
+		}
+	}
+
+	// Only the stream that's left should be handed out
+	f, _ := pool.LastFallback()
+	for i := 0; i < 3; i++ {
+		s := pool.TakeStream()
+		if s.id != f.StreamID {
+			t.Errorf("stream %v should be out of rotation", s.id)
+		}
+		pool.ReturnStream(s)
+	}
+
+	expected := y.DeepCopy()
+	for i := uint32(0); i < emulatedNumSlots; i++ {
+		cryptops.Mul2(g, x.Get(i), expected.Get(i))
+	}
+	checkSlots(t, expected, result)
+}
+
+// Streams can be marked unhealthy without falling back to the CPU
+func TestFallback_MarkUnhealthyOnly(t *testing.T) {
+	g := makeTestGroup2048()
+	b, pool := newTestPool(t, g, kernelMul3, 2, testHooks{onGet: failAlways})
+	pool.SetFallbackPolicy(FallbackPolicy{MarkUnhealthy: true})
+	x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+
+	err := b.Mul3Chunk(context.Background(), pool, g, x, x, x, x)
+	if err == nil {
+		t.Error("Mul3Chunk should have returned the kernel's error")
+	}
+	if pool.UnhealthyStreams() != 1 {
+		t.Errorf("the stream should have been marked unhealthy")
+	}
+	if pool.NumFallbacks() != 0 {
+		t.Errorf("nothing should have fallen back, but %v kernels did", pool.NumFallbacks())
+	}
+}
@@ -0,0 +1,139 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
+	"testing"
+	"time"
+)
+
+// The kernels of an oversized chunk should all run at the same time when
+// there are enough free streams, and their results should land in the right
+// slots
+func TestFanOut_Parallel(t *testing.T) {
+	g := makeTestGroup2048()
+	// emulatedNumSlots needs three kernels
+	const numStreams = 3
+	started := make(chan int, numStreams)
+	release := make(chan struct{})
+	b, pool := newTestPool(t, g, kernelPowmOdd, numStreams, testHooks{onGet: func(stream Stream) error {
+		started <- stream.id
+		<-release
+		return nil
+	}})
+	x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+	y := initRandomIntBuffer(g, emulatedNumSlots, 2, 32)
+	z := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+
+	chunkErr := make(chan error, 1)
+	go func() {
+		_, err := b.ExpChunk(context.Background(), pool, g, x, y, z)
+		chunkErr <- err
+	}()
+	// Every kernel has to start before any of them is allowed to finish
+	streamsUsed := make(map[int]bool)
+	for i := 0; i < numStreams; i++ {
+		select {
+		case id := <-started:
+			streamsUsed[id] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %v kernels ran at once", i)
+		}
+	}
+	if len(streamsUsed) != numStreams {
+		t.Errorf("the kernels should have run on %v different streams, got %v",
+			numStreams, len(streamsUsed))
+	}
+	close(release)
+	err := <-chunkErr
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+	for i := uint32(0); i < emulatedNumSlots; i++ {
+		cryptops.Exp(g, x.Get(i), y.Get(i), expected.Get(i))
+	}
+	checkSlots(t, expected, z)
+}
+
+// Streams that other callers have checked out aren't waited for, so fewer
+// streams run more kernels each
+func TestFanOut_BusyStreams(t *testing.T) {
+	g := makeTestGroup2048()
+	b, pool := newTestPool(t, g, kernelMul2, 3, testHooks{})
+	held := pool.TakeStream()
+	x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+	y := initRandomIntBuffer(g, emulatedNumSlots, 2, 0)
+	result := y.DeepCopy()
+
+	err := b.Mul2Chunk(context.Background(), pool, g, x, y, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.ReturnStream(held)
+
+	expected := y.DeepCopy()
+	for i := uint32(0); i < emulatedNumSlots; i++ {
+		cryptops.Mul2(g, x.Get(i), expected.Get(i))
+	}
+	checkSlots(t, expected, result)
+}
+
+// When one kernel fails, the chunk returns its error without waiting for the
+// kernels running on the other streams, and those never write their results
+func TestFanOut_FirstErrorCancels(t *testing.T) {
+	g := makeTestGroup2048()
+	injected := errors.New("injected failure")
+	const numStreams = 3
+	started := make(chan struct{}, numStreams)
+	release := make(chan struct{})
+	b, pool := newTestPool(t, g, kernelMul2, numStreams, testHooks{onGet: func(stream Stream) error {
+		if stream.id == 1 {
+			// Fail once the others are running
+			for i := 1; i < numStreams; i++ {
+				<-started
+			}
+			return injected
+		}
+		started <- struct{}{}
+		<-release
+		return nil
+	}})
+	x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+	y := initRandomIntBuffer(g, emulatedNumSlots, 2, 0)
+	result := y.DeepCopy()
+
+	chunkErr := make(chan error, 1)
+	go func() {
+		chunkErr <- b.Mul2Chunk(context.Background(), pool, g, x, y, result)
+	}()
+	select {
+	case err := <-chunkErr:
+		if err != injected {
+			t.Errorf("expected the kernel's error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the failed kernel should have stopped the chunk")
+	}
+
+	close(release)
+	// Every stream comes back once its kernel is done
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < numStreams; i++ {
+		_, err := pool.TakeStreamContext(ctx)
+		if err != nil {
+			t.Fatalf("stream %v wasn't returned: %v", i, err)
+		}
+	}
+	checkSlots(t, y, result)
+}
@@ -0,0 +1,100 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"gitlab.com/elixxir/crypto/cyclic"
+	"gitlab.com/xx_network/crypto/large"
+	"math/bits"
+)
+
+// fixedBaseWindow is the number of exponent bits handled by each row of a
+// fixedBaseTable. It must divide the word size so windows never straddle
+// two words of the exponent.
+const fixedBaseWindow = 4
+
+// fixedBaseTable holds precomputed powers of one base, so that raising that
+// base to many different exponents only takes one modular multiplication per
+// window of the exponent instead of a full exponentiation.
+// powers[j][d] = base**(d * 2**(j*fixedBaseWindow)) mod p
+type fixedBaseTable struct {
+	g      *cyclic.Group
+	base   *cyclic.Int
+	powers [][]*cyclic.Int
+}
+
+// newFixedBaseTable precomputes the powers of base needed to exponentiate it
+// by any exponent up to maxExpBits bits long
+func newFixedBaseTable(g *cyclic.Group, base *cyclic.Int, maxExpBits int) *fixedBaseTable {
+	numWindows := (maxExpBits + fixedBaseWindow - 1) / fixedBaseWindow
+	table := &fixedBaseTable{
+		g:      g,
+		base:   base,
+		powers: make([][]*cyclic.Int, numWindows),
+	}
+
+	// The first power in each row depends on the row before it, so those are
+	// found up front by repeated squaring
+	windowBases := make([]*cyclic.Int, numWindows)
+	if numWindows > 0 {
+		windowBases[0] = g.Mul(base, g.NewInt(1), g.NewInt(1))
+	}
+	for j := 1; j < numWindows; j++ {
+		windowBases[j] = g.Mul(windowBases[j-1], windowBases[j-1], g.NewInt(1))
+		for k := 1; k < fixedBaseWindow; k++ {
+			g.Mul(windowBases[j], windowBases[j], windowBases[j])
+		}
+	}
+
+	// After that, every row can be filled in independently
+	forEachSlot(uint32(numWindows), func(j uint32) {
+		row := make([]*cyclic.Int, 1<<fixedBaseWindow)
+		row[0] = g.NewInt(1)
+		row[1] = windowBases[j]
+		for d := 2; d < len(row); d++ {
+			row[d] = g.Mul(row[d-1], windowBases[j], g.NewInt(1))
+		}
+		table.powers[j] = row
+	})
+
+	return table
+}
+
+// exp sets z = base**y mod p and returns z. Exponents that are too long for
+// the table are computed directly with the group instead.
+// Safe to call concurrently as long as the z are different.
+func (t *fixedBaseTable) exp(y, z *cyclic.Int) *cyclic.Int {
+	// Copy the exponent in case z and y are the same int
+	words := append(large.Bits(nil), y.Bits()...)
+	if bitLen(words) > len(t.powers)*fixedBaseWindow {
+		return t.g.Exp(t.base, y, z)
+	}
+
+	windowsPerWord := bits.UintSize / fixedBaseWindow
+	one := t.g.NewInt(1)
+	t.g.Mul(one, one, z)
+	for j := 0; j < len(t.powers) && j/windowsPerWord < len(words); j++ {
+		shift := uint(j%windowsPerWord) * fixedBaseWindow
+		digit := (words[j/windowsPerWord] >> shift) & (1<<fixedBaseWindow - 1)
+		if digit != 0 {
+			t.g.Mul(z, t.powers[j][digit], z)
+		}
+	}
+	return z
+}
+
+// bitLen returns the length of a little-endian word slice in bits, ignoring
+// any zero words at the top
+func bitLen(words large.Bits) int {
+	for w := len(words) - 1; w >= 0; w-- {
+		if words[w] != 0 {
+			return w*bits.UintSize + bits.Len(uint(words[w]))
+		}
+	}
+	return 0
+}
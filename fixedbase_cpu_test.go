@@ -0,0 +1,56 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"gitlab.com/elixxir/crypto/cyclic"
+	"testing"
+)
+
+// Exponentiating with the table should give the same result as the group
+// for short exponents, full length exponents, and exponents that are too long
+// for the table
+func TestFixedBaseTable_Exp(t *testing.T) {
+	g := makeTestGroup2048()
+	base := g.Random(g.NewInt(1))
+	table := newFixedBaseTable(g, base, 256)
+
+	exponents := []struct {
+		name  string
+		value *cyclic.Int
+	}{
+		{"zero", g.NewInt(0)},
+		{"one", g.NewInt(1)},
+		{"small", g.NewInt(0x1234)},
+		{"256 bit", initRandomIntBuffer(g, 1, 42, 256/8).Get(0)},
+		{"too long for table", initRandomIntBuffer(g, 1, 43, 0).Get(0)},
+	}
+	for _, e := range exponents {
+		expected := g.Exp(base, e.value, g.NewInt(1))
+		actual := table.exp(e.value, g.NewInt(1))
+		if expected.Cmp(actual) != 0 {
+			t.Errorf("%v exponent: table result %v didn't match group result %v",
+				e.name, actual.Text(16), expected.Text(16))
+		}
+	}
+}
+
+// The result can be written over the exponent
+func TestFixedBaseTable_ExpAliased(t *testing.T) {
+	g := makeTestGroup2048()
+	base := g.Random(g.NewInt(1))
+	table := newFixedBaseTable(g, base, 2048)
+	y := g.Random(g.NewInt(1))
+
+	expected := g.Exp(base, y, g.NewInt(1))
+	table.exp(y, y)
+	if expected.Cmp(y) != 0 {
+		t.Errorf("aliased table result %v didn't match group result %v",
+			y.Text(16), expected.Text(16))
+	}
+}
@@ -5,9 +5,8 @@
 // LICENSE file.                                                              //
 ////////////////////////////////////////////////////////////////////////////////
 
-// gpu_test.go merely has helper functions used in all the other gpu tests.
-
-//+build linux,gpu
+// gpu_test.go merely has helper functions used in all the other tests.
+// They don't depend on CUDA, so they're available in every build.
 
 package gpumaths
 
@@ -0,0 +1,254 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+// health.go contains the tracking of each stream's failures. A CUDA stream
+// that goes into an error state fails every kernel run on it afterwards, so
+// streams that keep failing are quarantined and, if the pool's HealthPolicy
+// allows it, destroyed and created again.
+
+// StreamState is whether a stream is in rotation
+type StreamState int
+
+const (
+	// The stream is handed out as normal
+	StreamHealthy StreamState = iota
+	// The stream is out of rotation until it's recreated
+	StreamQuarantined
+	// The stream is out of rotation for good, as recreating it failed too
+	// many times
+	StreamFailed
+)
+
+func (s StreamState) String() string {
+	switch s {
+	case StreamHealthy:
+		return "healthy"
+	case StreamQuarantined:
+		return "quarantined"
+	case StreamFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("StreamState(%d)", int(s))
+	}
+}
+
+// HealthPolicy decides when streams are quarantined, and what's done with
+// them afterwards. The zero value never quarantines streams unless
+// FallbackPolicy.MarkUnhealthy is set, and never recreates them.
+type HealthPolicy struct {
+	// Quarantine a stream once this many kernels in a row have failed on it.
+	// Zero leaves it to FallbackPolicy.MarkUnhealthy, which quarantines
+	// streams after their first failure.
+	MaxFailures int
+	// Destroy quarantined streams and create them again once they're
+	// returned to the pool
+	Recreate bool
+	// Give up on a stream once recreating it has failed this many times in a
+	// row. Zero keeps trying.
+	MaxRecreateAttempts int
+}
+
+// StreamHealth is the failure history of one of the pool's streams
+type StreamHealth struct {
+	// Which stream this is, starting at 1
+	ID int
+	// Ordinal of the device the stream is on
+	Device int
+	State  StreamState
+	// How many kernels have failed on the stream, including before it was
+	// recreated
+	Failures int
+	// How many kernels have failed on the stream since one last succeeded
+	// or it was recreated
+	ConsecutiveFailures int
+	// How many times the stream has been recreated
+	Recreations int
+	// How many attempts to recreate the stream have failed since it was last
+	// recreated
+	FailedRecreations int
+	// The most recent error from a kernel or from recreating the stream
+	LastErr error
+}
+
+// HealthSummary describes the health of every stream in a pool
+type HealthSummary struct {
+	Healthy, Quarantined, Failed int
+	// Indexed by stream ID-1
+	Streams []StreamHealth
+}
+
+// SetHealthPolicy sets when the pool quarantines and recreates its streams
+func (sm *StreamPool) SetHealthPolicy(policy HealthPolicy) {
+	sm.mux.Lock()
+	sm.healthPolicy = policy
+	sm.mux.Unlock()
+}
+
+// GetHealthPolicy returns when the pool quarantines and recreates its streams
+func (sm *StreamPool) GetHealthPolicy() HealthPolicy {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	return sm.healthPolicy
+}
+
+// Health returns the health of each of the pool's streams
+func (sm *StreamPool) Health() HealthSummary {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	summary := HealthSummary{Streams: append([]StreamHealth(nil), sm.health...)}
+	for _, h := range sm.health {
+		switch h.State {
+		case StreamHealthy:
+			summary.Healthy++
+		case StreamQuarantined:
+			summary.Quarantined++
+		case StreamFailed:
+			summary.Failed++
+		}
+	}
+	return summary
+}
+
+// kernelSucceeded records that a kernel ran on the stream without failing
+func (sm *StreamPool) kernelSucceeded(stream Stream) {
+	sm.mux.Lock()
+	sm.health[stream.id-1].ConsecutiveFailures = 0
+	sm.mux.Unlock()
+}
+
+// streamFailed records a kernel that failed on the stream, and quarantines
+// the stream if it's failed too many times. mux must be held.
+func (sm *StreamPool) streamFailed(stream Stream, f Fallback) {
+	h := &sm.health[stream.id-1]
+	h.Failures++
+	h.ConsecutiveFailures++
+	h.LastErr = f.Err
+	if h.State != StreamHealthy {
+		return
+	}
+	if !sm.fallbackPolicy.MarkUnhealthy &&
+		(sm.healthPolicy.MaxFailures <= 0 || h.ConsecutiveFailures < sm.healthPolicy.MaxFailures) {
+		return
+	}
+	if sm.numUnhealthy+1 < len(sm.streams) {
+		jww.WARN.Printf("Taking stream %v out of rotation after %v failed: %v",
+			stream.id, f.Op, f.Err)
+		h.State = StreamQuarantined
+		sm.numUnhealthy++
+	} else {
+		jww.WARN.Printf("Keeping stream %v in rotation after %v failed, as it's the last one left: %v",
+			stream.id, f.Op, f.Err)
+	}
+}
+
+// shouldRecreate returns whether a stream that's being returned should be
+// recreated first. mux must be held.
+func (sm *StreamPool) shouldRecreate(id int) bool {
+	return sm.health[id-1].State == StreamQuarantined && sm.healthPolicy.Recreate &&
+		sm.createStream != nil && !sm.closed
+}
+
+// recreate destroys a quarantined stream and creates another on the same
+// device to take its place. It returns whether the stream is back in
+// rotation. The stream must stay checked out while it's recreated, so that
+// nothing else uses or destroys it, and mux must not be held.
+func (sm *StreamPool) recreate(id int) bool {
+	sm.mux.Lock()
+	old := sm.streams[id-1]
+	released := sm.released[id-1]
+	sm.mux.Unlock()
+
+	// An earlier attempt could have destroyed the stream without managing to
+	// create its replacement
+	var err error
+	if !released {
+		err = sm.destroyStreams([]Stream{old})
+		if err != nil {
+			err = errors.Wrapf(err, "couldn't destroy stream %v", id)
+		} else {
+			released = true
+		}
+	}
+	var s Stream
+	if err == nil {
+		s, err = sm.createStream(old.device)
+		if err != nil {
+			err = errors.Wrapf(err, "couldn't create a stream to replace stream %v", id)
+		}
+	}
+
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	sm.released[id-1] = released
+	h := &sm.health[id-1]
+	if err != nil {
+		h.FailedRecreations++
+		h.LastErr = err
+		if sm.healthPolicy.MaxRecreateAttempts > 0 &&
+			h.FailedRecreations >= sm.healthPolicy.MaxRecreateAttempts {
+			jww.ERROR.Printf("Giving up on stream %v after %v attempts to recreate it: %v",
+				id, h.FailedRecreations, err)
+			h.State = StreamFailed
+		} else {
+			jww.WARN.Printf("Couldn't recreate stream %v: %v", id, err)
+		}
+		return false
+	}
+	s.id = id
+	sm.streams[id-1] = s
+	sm.released[id-1] = false
+	h.State = StreamHealthy
+	h.ConsecutiveFailures = 0
+	h.FailedRecreations = 0
+	h.Recreations++
+	sm.numUnhealthy--
+	jww.INFO.Printf("Recreated stream %v, putting it back in rotation", id)
+	return true
+}
+
+// HealStreams tries to recreate every quarantined stream that isn't checked
+// out, whether or not the HealthPolicy recreates streams. It returns how many
+// were put back in rotation.
+func (sm *StreamPool) HealStreams() int {
+	sm.mux.Lock()
+	if sm.closed || sm.createStream == nil {
+		sm.mux.Unlock()
+		return 0
+	}
+	var ids []int
+	for i := range sm.health {
+		if sm.health[i].State == StreamQuarantined && !sm.checkedOut[i] {
+			// Check the stream out while it's recreated
+			sm.checkedOut[i] = true
+			sm.numCheckedOut++
+			sm.busy[sm.deviceIndex[i]]++
+			sm.recreating[i] = true
+			ids = append(ids, i+1)
+		}
+	}
+	sm.mux.Unlock()
+
+	healed := 0
+	for _, id := range ids {
+		if sm.recreate(id) {
+			healed++
+		}
+		sm.mux.Lock()
+		sm.recreating[id-1] = false
+		sm.checkIn(id)
+		sm.mux.Unlock()
+	}
+	return healed
+}
@@ -0,0 +1,208 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
+	"sync"
+	"testing"
+)
+
+// streamLog keeps count of the streams a test device creates and destroys,
+// and can fail to create them
+type streamLog struct {
+	mux        sync.Mutex
+	created    int
+	destroyed  int
+	failCreate bool
+}
+
+func (l *streamLog) createStreams(ordinal int, numStreams int, capacity int) ([]Stream, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.failCreate {
+		return nil, errors.New("injected failure")
+	}
+	l.created += numStreams
+	return emulatedDevice{}.createStreams(ordinal, numStreams, capacity)
+}
+
+func (l *streamLog) destroyStreams(streams []Stream) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.destroyed += len(streams)
+	return nil
+}
+
+func (l *streamLog) counts() (int, int) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.created, l.destroyed
+}
+
+// newRecreatingPool makes a pool on a test device that logs its streams, and
+// whose kernels fail whenever failGet returns an error
+func newRecreatingPool(t *testing.T, numStreams int, failGet func(Stream) error) (gpuBackend, *StreamPool, *streamLog) {
+	log := &streamLog{}
+	b, pool := newTestPool(t, makeTestGroup2048(), kernelMul2, numStreams, testHooks{
+		onGet:          failGet,
+		createStreams:  log.createStreams,
+		destroyStreams: log.destroyStreams,
+	})
+	return b, pool, log
+}
+
+var injectedFailure = Fallback{Op: "Mul2Chunk", Err: errors.New("injected failure")}
+
+// Streams should be quarantined once enough kernels in a row fail on them,
+// and stay out of rotation until they're healed
+func TestHealth_Quarantine(t *testing.T) {
+	_, pool, log := newRecreatingPool(t, 2, failAlways)
+	pool.SetHealthPolicy(HealthPolicy{MaxFailures: 2})
+
+	s := pool.TakeStream()
+	pool.kernelFailed(s, injectedFailure)
+	pool.kernelSucceeded(s)
+	pool.kernelFailed(s, injectedFailure)
+	if pool.Health().Quarantined != 0 {
+		t.Fatal("a success should have reset the stream's failures")
+	}
+	pool.kernelFailed(s, injectedFailure)
+	pool.ReturnStream(s)
+
+	health := pool.Health()
+	if health.Healthy != 1 || health.Quarantined != 1 || pool.UnhealthyStreams() != 1 {
+		t.Fatalf("one stream should be quarantined, got %+v", health)
+	}
+	h := health.Streams[s.id-1]
+	if h.State != StreamQuarantined || h.Failures != 3 || h.ConsecutiveFailures != 2 || h.LastErr == nil {
+		t.Errorf("wrong health for the failed stream: %+v", h)
+	}
+	for i := 0; i < 3; i++ {
+		other := pool.TakeStream()
+		if other.id == s.id {
+			t.Error("the quarantined stream shouldn't be handed out")
+		}
+		pool.ReturnStream(other)
+	}
+
+	if pool.HealStreams() != 1 {
+		t.Fatal("the quarantined stream should have been recreated")
+	}
+	created, destroyed := log.counts()
+	if created != 3 || destroyed != 1 {
+		t.Errorf("the stream should have been destroyed and created again, "+
+			"but %v were created and %v destroyed", created, destroyed)
+	}
+	h = pool.Health().Streams[s.id-1]
+	if h.State != StreamHealthy || h.Recreations != 1 || h.ConsecutiveFailures != 0 || h.Failures != 3 {
+		t.Errorf("wrong health for the recreated stream: %+v", h)
+	}
+	if pool.UnhealthyStreams() != 0 {
+		t.Error("every stream should be back in rotation")
+	}
+}
+
+// With Recreate, a quarantined stream is replaced as soon as it's returned
+func TestHealth_RecreateOnReturn(t *testing.T) {
+	_, pool, _ := newRecreatingPool(t, 2, failAlways)
+	pool.SetHealthPolicy(HealthPolicy{MaxFailures: 1, Recreate: true})
+
+	s := pool.TakeStream()
+	pool.kernelFailed(s, injectedFailure)
+	pool.ReturnStream(s)
+
+	health := pool.Health()
+	if health.Healthy != 2 || health.Streams[s.id-1].Recreations != 1 {
+		t.Fatalf("the stream should have been recreated, got %+v", health)
+	}
+	if pool.streams[s.id-1].s == s.s || pool.streams[s.id-1].id != s.id {
+		t.Error("the pool should have a new stream with the same id")
+	}
+	// The replacement should be the one handed out
+	var found bool
+	for i := 0; i < 2; i++ {
+		taken := pool.TakeStream()
+		found = found || taken.s == pool.streams[s.id-1].s
+		defer pool.ReturnStream(taken)
+	}
+	if !found {
+		t.Error("the recreated stream should be back in rotation")
+	}
+}
+
+// A stream that can't be recreated is given up on, and isn't destroyed again
+// with the pool
+func TestHealth_RecreateFails(t *testing.T) {
+	_, pool, log := newRecreatingPool(t, 2, failAlways)
+	pool.SetHealthPolicy(HealthPolicy{MaxFailures: 1, Recreate: true, MaxRecreateAttempts: 2})
+	log.mux.Lock()
+	log.failCreate = true
+	log.mux.Unlock()
+
+	s := pool.TakeStream()
+	pool.kernelFailed(s, injectedFailure)
+	pool.ReturnStream(s)
+	h := pool.Health().Streams[s.id-1]
+	if h.State != StreamQuarantined || h.FailedRecreations != 1 || h.LastErr == nil {
+		t.Fatalf("the stream should still be quarantined, got %+v", h)
+	}
+
+	if pool.HealStreams() != 0 {
+		t.Error("no streams should have been healed")
+	}
+	health := pool.Health()
+	if health.Failed != 1 || health.Streams[s.id-1].FailedRecreations != 2 {
+		t.Errorf("the pool should have given up on the stream, got %+v", health)
+	}
+	if pool.HealStreams() != 0 {
+		t.Error("failed streams shouldn't be recreated")
+	}
+
+	err := pool.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, destroyed := log.counts()
+	if destroyed != 2 {
+		t.Errorf("each stream should have been destroyed once, but %v were destroyed", destroyed)
+	}
+}
+
+// Kernels that fail during a chunk should get their stream recreated, while
+// the chunk still gets the right results
+func TestHealth_Chunk(t *testing.T) {
+	g := makeTestGroup2048()
+	b, pool, _ := newRecreatingPool(t, 2, failCalls(1))
+	pool.SetFallbackPolicy(FallbackPolicy{UseCPU: true})
+	pool.SetHealthPolicy(HealthPolicy{MaxFailures: 1, Recreate: true})
+	x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+	y := initRandomIntBuffer(g, emulatedNumSlots, 2, 0)
+	result := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+
+	err := b.Mul2Chunk(context.Background(), pool, g, x, y, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := y.DeepCopy()
+	for i := uint32(0); i < emulatedNumSlots; i++ {
+		cryptops.Mul2(g, x.Get(i), expected.Get(i))
+	}
+	checkSlots(t, expected, result)
+
+	health := pool.Health()
+	recreations := 0
+	for _, h := range health.Streams {
+		recreations += h.Recreations
+	}
+	if health.Healthy != 2 || recreations != 1 {
+		t.Errorf("the stream the kernel failed on should have been recreated, got %+v", health)
+	}
+}
@@ -0,0 +1,149 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"math"
+	"time"
+)
+
+// hybrid.go contains the split of a chunk between a GPU stream and the CPU
+// workers. The CPU gets a share of each chunk that's in proportion to how
+// fast it's been computing that op at that width compared to the GPU, so
+// both finish at about the same time.
+
+// Share of a chunk that the CPU gets before there are timings to go on
+const defaultHybridCPUShare = 0.1
+
+// Weight the newest timing gets if the policy doesn't say
+const defaultHybridSmoothing = 0.2
+
+// HybridPolicy decides whether chunks run on the GPU get split with the CPU.
+// The zero value runs every slot on the GPU.
+type HybridPolicy struct {
+	// Split chunks between the GPU and the CPU
+	Enabled bool
+	// Weight of the newest timing in the running throughput estimates, in
+	// (0, 1]. Higher values adapt faster but are noisier. Zero uses the
+	// default.
+	Smoothing float64
+}
+
+// hybridKey is what throughput gets measured for
+type hybridKey struct {
+	op     string
+	bitLen int
+}
+
+// hybridEstimate is the recent throughput, in slots per second, of an op on
+// the GPU and the CPU, as exponentially weighted moving averages
+type hybridEstimate struct {
+	gpuRate float64
+	cpuRate float64
+}
+
+// cpuShare returns the fraction of a chunk that the CPU should compute
+func (e *hybridEstimate) cpuShare() float64 {
+	if e.gpuRate <= 0 || e.cpuRate <= 0 {
+		return defaultHybridCPUShare
+	}
+	return e.cpuRate / (e.cpuRate + e.gpuRate)
+}
+
+// update folds one chunk's timings into the estimate
+func (e *hybridEstimate) update(gpuSlots uint32, gpuTime time.Duration,
+	cpuSlots uint32, cpuTime time.Duration, smoothing float64) {
+	e.gpuRate = smooth(e.gpuRate, rate(gpuSlots, gpuTime), smoothing)
+	e.cpuRate = smooth(e.cpuRate, rate(cpuSlots, cpuTime), smoothing)
+}
+
+func rate(slots uint32, elapsed time.Duration) float64 {
+	// Avoid dividing by zero on coarse clocks
+	if elapsed <= 0 {
+		elapsed = time.Nanosecond
+	}
+	return float64(slots) / elapsed.Seconds()
+}
+
+// The first sample becomes the average as-is
+func smooth(average, sample, smoothing float64) float64 {
+	if average <= 0 {
+		return sample
+	}
+	return smoothing*sample + (1-smoothing)*average
+}
+
+// SetHybridPolicy sets whether chunks run on the pool's streams get split
+// with the CPU
+func (sm *StreamPool) SetHybridPolicy(policy HybridPolicy) {
+	sm.mux.Lock()
+	sm.hybridPolicy = policy
+	sm.mux.Unlock()
+}
+
+// GetHybridPolicy returns whether chunks get split with the CPU
+func (sm *StreamPool) GetHybridPolicy() HybridPolicy {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	return sm.hybridPolicy
+}
+
+// HybridCPUShare returns the fraction of a chunk that the CPU will get the
+// next time the named op (e.g. "ExpChunk") runs with numbers of bitLen bits
+func (sm *StreamPool) HybridCPUShare(op string, bitLen int) float64 {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	e, ok := sm.hybridEstimates[hybridKey{op: op, bitLen: bitLen}]
+	if !ok {
+		return defaultHybridCPUShare
+	}
+	return e.cpuShare()
+}
+
+// hybridSplit returns the first slot of a chunk that the CPU should compute.
+// All of the chunk runs on the GPU if the policy is off or the chunk is too
+// small to split. Otherwise both sides get at least one slot, so they both
+// keep getting timed.
+func (sm *StreamPool) hybridSplit(key hybridKey, numSlots uint32) uint32 {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	if !sm.hybridPolicy.Enabled || numSlots < 2 {
+		return numSlots
+	}
+	share := defaultHybridCPUShare
+	if e, ok := sm.hybridEstimates[key]; ok {
+		share = e.cpuShare()
+	}
+	cpuSlots := uint32(math.Round(share * float64(numSlots)))
+	if cpuSlots < 1 {
+		cpuSlots = 1
+	} else if cpuSlots > numSlots-1 {
+		cpuSlots = numSlots - 1
+	}
+	return numSlots - cpuSlots
+}
+
+// recordHybridTimings updates the throughput estimates after a split chunk
+func (sm *StreamPool) recordHybridTimings(key hybridKey, gpuSlots uint32,
+	gpuTime time.Duration, cpuSlots uint32, cpuTime time.Duration) {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	smoothing := sm.hybridPolicy.Smoothing
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = defaultHybridSmoothing
+	}
+	if sm.hybridEstimates == nil {
+		sm.hybridEstimates = make(map[hybridKey]*hybridEstimate)
+	}
+	e, ok := sm.hybridEstimates[key]
+	if !ok {
+		e = &hybridEstimate{}
+		sm.hybridEstimates[key] = e
+	}
+	e.update(gpuSlots, gpuTime, cpuSlots, cpuTime, smoothing)
+}
@@ -0,0 +1,119 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
+	"math"
+	"testing"
+	"time"
+)
+
+// If the CPU is three times slower than the GPU, it should end up with a
+// quarter of each chunk
+func TestHybridEstimate_Converges(t *testing.T) {
+	var e hybridEstimate
+	if e.cpuShare() != defaultHybridCPUShare {
+		t.Errorf("share without timings should be the default, got %v", e.cpuShare())
+	}
+	// Start from a misleading timing
+	e.update(100, time.Second, 100, time.Second, 0.5)
+	for i := 0; i < 30; i++ {
+		e.update(300, time.Second, 100, time.Second, 0.5)
+	}
+	if math.Abs(e.cpuShare()-0.25) > 0.001 {
+		t.Errorf("CPU share should have converged to 0.25, got %v", e.cpuShare())
+	}
+}
+
+func TestStreamPool_HybridSplit(t *testing.T) {
+	pool, err := cpuBackend{}.NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := hybridKey{op: "ExpChunk", bitLen: 2048}
+	if pool.hybridSplit(key, 100) != 100 {
+		t.Error("chunks shouldn't be split while the policy is off")
+	}
+
+	pool.SetHybridPolicy(HybridPolicy{Enabled: true})
+	if pool.hybridSplit(key, 100) != 90 {
+		t.Errorf("the CPU should get the default share without timings, but got %v slots",
+			100-pool.hybridSplit(key, 100))
+	}
+	if pool.hybridSplit(key, 1) != 1 {
+		t.Error("a single slot shouldn't be split")
+	}
+
+	// A CPU that's much faster still leaves the GPU a slot
+	pool.recordHybridTimings(key, 1, time.Hour, 1000, time.Millisecond)
+	if pool.hybridSplit(key, 10) != 1 {
+		t.Errorf("the GPU should keep one slot, but got %v", pool.hybridSplit(key, 10))
+	}
+	// And a much slower one still gets a slot
+	pool.recordHybridTimings(hybridKey{op: "Mul2Chunk", bitLen: 2048},
+		1000, time.Millisecond, 1, time.Hour)
+	if pool.hybridSplit(hybridKey{op: "Mul2Chunk", bitLen: 2048}, 10) != 9 {
+		t.Error("the CPU should keep one slot")
+	}
+
+	// Estimates are kept separately for every width
+	if pool.HybridCPUShare("ExpChunk", 4096) != defaultHybridCPUShare {
+		t.Error("timings at 2048 bits shouldn't affect 4096 bits")
+	}
+}
+
+// A split chunk should have the same results as one computed all on the GPU,
+// and it should leave timings behind for the next one
+func TestHybrid_ExpChunk(t *testing.T) {
+	g := makeTestGroup2048()
+	b, pool := newEmulatedPool(t, g, kernelPowmOdd)
+	pool.SetHybridPolicy(HybridPolicy{Enabled: true})
+	const numSlots = 20
+	x := initRandomIntBuffer(g, numSlots, 1, 0)
+	y := initRandomIntBuffer(g, numSlots, 2, 32)
+	z := g.NewIntBuffer(numSlots, g.NewInt(1))
+
+	for i := 0; i < 3; i++ {
+		_, err := b.ExpChunk(context.Background(), pool, g, x, y, z)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := g.NewIntBuffer(numSlots, g.NewInt(1))
+	for i := uint32(0); i < numSlots; i++ {
+		cryptops.Exp(g, x.Get(i), y.Get(i), expected.Get(i))
+	}
+	checkSlots(t, expected, z)
+
+	e, ok := pool.hybridEstimates[hybridKey{op: "ExpChunk", bitLen: 2048}]
+	if !ok || e.gpuRate <= 0 || e.cpuRate <= 0 {
+		t.Errorf("both sides should have been timed, but got %+v", e)
+	}
+}
+
+// Disabling the policy again puts whole chunks back on the GPU
+func TestHybrid_Disable(t *testing.T) {
+	g := makeTestGroup2048()
+	b, pool := newEmulatedPool(t, g, kernelMul2)
+	pool.SetHybridPolicy(HybridPolicy{Enabled: true})
+	pool.SetHybridPolicy(HybridPolicy{})
+	x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+	y := initRandomIntBuffer(g, emulatedNumSlots, 2, 0)
+	result := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+
+	err := b.Mul2Chunk(context.Background(), pool, g, x, y, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.hybridEstimates) != 0 {
+		t.Error("nothing should have been split with the CPU")
+	}
+}
@@ -0,0 +1,64 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"gitlab.com/elixxir/crypto/cyclic"
+)
+
+// ints.go contains the interface that the backends read and write each
+// slot's numbers through, so chunks can run on numbers wherever the caller
+// keeps them, without copying them into an IntBuffer first.
+
+// Ints is the numbers of a chunk's slots, one per slot.
+// *cyclic.IntBuffer and IntSlice both implement it. Backends write results
+// into the ints that Get returns, so Get must return the same int each time
+// it's called with the same index.
+type Ints interface {
+	Get(index uint32) *cyclic.Int
+	Len() int
+}
+
+// IntSlice implements Ints with a slice of cyclic ints
+type IntSlice []*cyclic.Int
+
+func (s IntSlice) Get(index uint32) *cyclic.Int {
+	return s[index]
+}
+
+func (s IntSlice) Len() int {
+	return len(s)
+}
+
+// intRange is the slots in [start, end) of some other Ints
+type intRange struct {
+	ints       Ints
+	start, end uint32
+}
+
+func (r intRange) Get(index uint32) *cyclic.Int {
+	return r.ints.Get(r.start + index)
+}
+
+func (r intRange) Len() int {
+	return int(r.end - r.start)
+}
+
+// subInts returns the slots in [start, end) of ints, without copying them
+func subInts(ints Ints, start, end uint32) Ints {
+	switch ints := ints.(type) {
+	case *cyclic.IntBuffer:
+		return ints.GetSubBuffer(start, end)
+	case IntSlice:
+		return ints[start:end]
+	case intRange:
+		return intRange{ints: ints.ints, start: ints.start + start, end: ints.start + end}
+	default:
+		return intRange{ints: ints, start: start, end: end}
+	}
+}
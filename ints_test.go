@@ -0,0 +1,210 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"gitlab.com/elixxir/crypto/cyclic"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
+	"testing"
+)
+
+// payload is an example of numbers kept in per-slot structs, which is what
+// Ints is for
+type payload struct {
+	x, y, z, result *cyclic.Int
+}
+
+// payloadField implements Ints with one field of each payload
+type payloadField struct {
+	payloads []payload
+	field    func(p *payload) *cyclic.Int
+}
+
+func (f payloadField) Get(index uint32) *cyclic.Int {
+	return f.field(&f.payloads[index])
+}
+
+func (f payloadField) Len() int {
+	return len(f.payloads)
+}
+
+// intsOf copies the pointers to a buffer's ints into a slice
+func intsOf(b *cyclic.IntBuffer) []*cyclic.Int {
+	s := make([]*cyclic.Int, b.Len())
+	for i := range s {
+		s[i] = b.Get(uint32(i))
+	}
+	return s
+}
+
+// Sub-ranges of every kind of Ints should see the same slots
+func TestSubInts(t *testing.T) {
+	g := makeTestGroup2048()
+	buffer := initRandomIntBuffer(g, 10, 1, 0)
+	payloads := make([]payload, 10)
+	for i := range payloads {
+		payloads[i].x = buffer.Get(uint32(i))
+	}
+	for name, ints := range map[string]Ints{
+		"buffer":  buffer,
+		"slice":   IntSlice(intsOf(buffer)),
+		"generic": payloadField{payloads, func(p *payload) *cyclic.Int { return p.x }},
+	} {
+		sub := subInts(subInts(ints, 2, 9), 1, 5)
+		if sub.Len() != 4 {
+			t.Errorf("%v: expected 4 slots, got %v", name, sub.Len())
+		}
+		for i := uint32(0); i < uint32(sub.Len()); i++ {
+			if sub.Get(i) != buffer.Get(i+3) {
+				t.Errorf("%v: slot %v of the sub-range wasn't slot %v", name, i, i+3)
+			}
+		}
+	}
+}
+
+// The Slice functions should compute the same thing as the Chunk functions
+func TestSliceFunctions(t *testing.T) {
+	g := makeTestGroup2048()
+	const numSlots = 9
+	pool, err := NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := initRandomIntBuffer(g, numSlots, 1, 0)
+	y := initRandomIntBuffer(g, numSlots, 2, 32)
+	z := initRandomIntBuffer(g, numSlots, 3, 0)
+	fresh := func() []*cyclic.Int {
+		return intsOf(g.NewIntBuffer(numSlots, g.NewInt(1)))
+	}
+
+	expResult, err := ExpSlice(pool, g, intsOf(x), intsOf(y), fresh())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(0); i < numSlots; i++ {
+		if cryptops.Exp(g, x.Get(i), y.Get(i), g.NewInt(1)).Cmp(expResult[i]) != 0 {
+			t.Errorf("ExpSlice was wrong in slot %v", i)
+		}
+	}
+
+	publicCypherKey := g.FindSmallCoprimeInverse(g.NewInt(1), 256)
+	revealResult := fresh()
+	err = RevealSlice(pool, g, publicCypherKey, intsOf(z), revealResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(0); i < numSlots; i++ {
+		if cryptops.RootCoprime(g, z.Get(i), publicCypherKey, g.NewInt(1)).Cmp(revealResult[i]) != 0 {
+			t.Errorf("RevealSlice was wrong in slot %v", i)
+		}
+	}
+
+	mul3Result := fresh()
+	err = Mul3Slice(pool, g, intsOf(x), intsOf(y), intsOf(z), mul3Result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(0); i < numSlots; i++ {
+		if cryptops.Mul3(g, x.Get(i), y.Get(i), z.Get(i).DeepCopy()).Cmp(mul3Result[i]) != 0 {
+			t.Errorf("Mul3Slice was wrong in slot %v", i)
+		}
+	}
+
+	ecrKey, cypher := x.DeepCopy(), z.DeepCopy()
+	ecrKeySlice, cypherSlice := intsOf(x.DeepCopy()), intsOf(z.DeepCopy())
+	err = ElGamalChunk(pool, g, y, y, publicCypherKey, ecrKey, cypher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ElGamalSlice(pool, g, intsOf(y), intsOf(y), publicCypherKey, ecrKeySlice, cypherSlice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(0); i < numSlots; i++ {
+		if ecrKey.Get(i).Cmp(ecrKeySlice[i]) != 0 || cypher.Get(i).Cmp(cypherSlice[i]) != 0 {
+			t.Errorf("ElGamalSlice didn't match ElGamalChunk in slot %v", i)
+		}
+	}
+}
+
+// The gpu implementation should read and write numbers through any Ints,
+// including when a chunk is split over several kernels
+func TestEmulatedDevice_Ints(t *testing.T) {
+	g := makeTestGroup2048()
+	b, pool := newEmulatedPool(t, g, kernelMul3)
+	x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+	y := initRandomIntBuffer(g, emulatedNumSlots, 2, 0)
+	z := initRandomIntBuffer(g, emulatedNumSlots, 3, 0)
+	payloads := make([]payload, emulatedNumSlots)
+	for i := range payloads {
+		payloads[i] = payload{x: x.Get(uint32(i)), y: y.Get(uint32(i)), z: z.Get(uint32(i)),
+			result: g.NewInt(1)}
+	}
+	field := func(f func(p *payload) *cyclic.Int) Ints {
+		return payloadField{payloads, f}
+	}
+
+	err := b.Mul3Chunk(context.Background(), pool, g,
+		field(func(p *payload) *cyclic.Int { return p.x }),
+		field(func(p *payload) *cyclic.Int { return p.y }),
+		field(func(p *payload) *cyclic.Int { return p.z }),
+		field(func(p *payload) *cyclic.Int { return p.result }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(0); i < emulatedNumSlots; i++ {
+		expected := cryptops.Mul3(g, x.Get(i), y.Get(i), z.Get(i).DeepCopy())
+		if expected.Cmp(payloads[i].result) != 0 {
+			t.Errorf("Mul3Chunk through a payload's fields was wrong in slot %v", i)
+		}
+	}
+}
+
+// The package's chunk entry points should take Ints the caller implements
+func TestChunkContext_Ints(t *testing.T) {
+	g := makeTestGroup2048()
+	const numSlots = 5
+	pool, err := NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := initRandomIntBuffer(g, numSlots, 1, 0)
+	y := initRandomIntBuffer(g, numSlots, 2, 32)
+	payloads := make([]payload, numSlots)
+	for i := range payloads {
+		payloads[i] = payload{x: x.Get(uint32(i)), y: y.Get(uint32(i)),
+			z: g.NewInt(1), result: g.NewInt(1)}
+	}
+	field := func(f func(p *payload) *cyclic.Int) Ints {
+		return payloadField{payloads, f}
+	}
+	xs := field(func(p *payload) *cyclic.Int { return p.x })
+	ys := field(func(p *payload) *cyclic.Int { return p.y })
+	zs := field(func(p *payload) *cyclic.Int { return p.z })
+	results := field(func(p *payload) *cyclic.Int { return p.result })
+
+	ctx := context.Background()
+	_, err = ExpChunkContext(ctx, pool, g, xs, ys, zs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Mul2ChunkAsync(ctx, pool, g, xs, zs, results).Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(0); i < numSlots; i++ {
+		z := cryptops.Exp(g, x.Get(i), y.Get(i), g.NewInt(1))
+		if z.Cmp(payloads[i].z) != 0 {
+			t.Errorf("ExpChunkContext was wrong in slot %v", i)
+		}
+		if cryptops.Mul2(g, x.Get(i), z).Cmp(payloads[i].result) != 0 {
+			t.Errorf("Mul2ChunkAsync was wrong in slot %v", i)
+		}
+	}
+}
@@ -23,7 +23,7 @@ func TestElGamalChunk(t *testing.T) {
 	publicCypherKey := g.Random(g.NewInt(2))
 	ecrKey := g.NewIntBuffer(numSlots, g.NewInt(2))
 	cypher := g.NewIntBuffer(numSlots, g.NewInt(2))
-	env := gpumaths4096{}
+	env := mustChooseEnv(t, cudaDevice{}, 4096)
 	for i := 0; i < numSlots; i++ {
 		g.Random(key.Get(uint32(i)))
 		g.Random(privateKey.Get(uint32(i)))
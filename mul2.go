@@ -7,11 +7,15 @@
 
 package gpumaths
 
-import "gitlab.com/elixxir/crypto/cyclic"
+import (
+	"context"
+	"gitlab.com/elixxir/crypto/cyclic"
+)
 
 // mul2.go contains the input, results, and other types for running the mul2
-// operation against the GPU. The actual GPU call is in mul2_gpu.go and is
-// marked to require `-tags cuda` in your build.
+// operation on the active backend. The actual GPU call is in mul2_gpu.go and
+// runs on CUDA when built with `-tags gpu`. The CPU version is in
+// mul2_cpu.go.
 
 // Mul2ChunkPrototype defines the function type for running the mul2
 // kernel in the GPU.
@@ -22,6 +26,57 @@ type Mul2ChunkPrototype func(p *StreamPool, g *cyclic.Group,
 type Mul2SlicePrototype func(p *StreamPool, g *cyclic.Group,
 	x *cyclic.IntBuffer, y, result []*cyclic.Int) error
 
+// Mul2Chunk performs the mul2 operation on the cypher and precomputation
+// payloads. It runs on the active backend.
+// All int buffers must have the same length, or ErrBufferLengthMismatch is
+// returned
+var Mul2Chunk Mul2ChunkPrototype = func(p *StreamPool, g *cyclic.Group,
+	x, y, results *cyclic.IntBuffer) error {
+	return Mul2ChunkContext(context.Background(), p, g, x, y, results)
+}
+
+// Mul2ChunkContext is Mul2Chunk, but it takes any Ints, and it stops between
+// kernels (or slots, on the CPU) once ctx is done, and returns ctx.Err().
+// Slots that hadn't been computed yet are left as they were.
+func Mul2ChunkContext(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x, y, results Ints) error {
+	return ActiveBackend().Mul2Chunk(ctx, p, g, x, y, results)
+}
+
+// Mul2ChunkAsync is Mul2ChunkContext, but it runs in the background.
+// The outputs can be used once the returned handle is done.
+func Mul2ChunkAsync(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x, y, results Ints) *ChunkHandle {
+	b := ActiveBackend()
+	return goChunk(func() error {
+		return b.Mul2Chunk(ctx, p, g, x, y, results)
+	})
+}
+
+// Mul2Slice performs the mul2 operation with slices of cyclic ints as the
+// second operand and the result. It runs on the active backend.
+// x, y and result must have the same length, or ErrBufferLengthMismatch is
+// returned
+var Mul2Slice Mul2SlicePrototype = func(p *StreamPool, g *cyclic.Group,
+	x *cyclic.IntBuffer, y, result []*cyclic.Int) error {
+	return Mul2SliceContext(context.Background(), p, g, x, y, result)
+}
+
+// Mul2SliceContext is Mul2Slice, but it stops between kernels (or slots, on
+// the CPU) once ctx is done, and returns ctx.Err(). Slots that hadn't been
+// computed yet are left as they were.
+func Mul2SliceContext(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x *cyclic.IntBuffer, y, result []*cyclic.Int) error {
+	return Mul2ChunkContext(ctx, p, g, x, IntSlice(y), IntSlice(result))
+}
+
+// Mul2SliceAsync is Mul2SliceContext, but it runs in the background.
+// The outputs can be used once the returned handle is done.
+func Mul2SliceAsync(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x *cyclic.IntBuffer, y, result []*cyclic.Int) *ChunkHandle {
+	return Mul2ChunkAsync(ctx, p, g, x, IntSlice(y), IntSlice(result))
+}
+
 // GetInputSize is how big chunk sizes should be to run the mul2 operation
 func (Mul2ChunkPrototype) GetInputSize() uint32 {
 	return 256
@@ -5,22 +5,32 @@
 // LICENSE file.                                                              //
 ////////////////////////////////////////////////////////////////////////////////
 
-//+build !linux !gpu
-
 package gpumaths
 
 import (
-	"errors"
+	"context"
 	"gitlab.com/elixxir/crypto/cyclic"
 )
 
-// Mul2Chunk is stubbed unless GPU is present.
-var Mul2Chunk Mul2ChunkPrototype = func(p *StreamPool, g *cyclic.Group,
-	result *cyclic.IntBuffer, x *cyclic.IntBuffer, y *cyclic.IntBuffer) error {
-	return errors.New(NoGpuErrStr)
-}
-
-var Mul2Slice Mul2SlicePrototype = func(p *StreamPool, g *cyclic.Group,
-	x *cyclic.IntBuffer, y, result []*cyclic.Int) error {
-	return errors.New(NoGpuErrStr)
+// Mul2Chunk performs the mul2 operation on the CPU, so
+// results[i] = x[i]*y[i] mod p. Slots are computed in parallel across all
+// available cores.
+// Precondition: All int buffers must have the same length
+func (cpuBackend) Mul2Chunk(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x, y, results Ints) error {
+	err := checkLengths("Mul2Chunk", x.Len(), y.Len(), results.Len())
+	if err != nil {
+		return err
+	}
+	err = p.validate("Mul2Chunk", g, elements("x", x), elements("y", y))
+	if err != nil {
+		return err
+	}
+	release, err := p.acquire(ctx, "Mul2Chunk", uint32(x.Len()))
+	if err != nil {
+		return err
+	}
+	return release(forEachSlotContext(ctx, uint32(x.Len()), func(i uint32) {
+		g.Mul(x.Get(i), y.Get(i), results.Get(i))
+	}))
 }
@@ -0,0 +1,65 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"gitlab.com/elixxir/crypto/cyclic"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
+	"testing"
+)
+
+// CPU Mul2Chunk results should match cryptops.Mul2 in every slot
+func TestCpuBackend_Mul2Chunk(t *testing.T) {
+	const numSlots = 40
+	g := makeTestGroup2048()
+	x := initRandomIntBuffer(g, numSlots, 42, 0)
+	y := initRandomIntBuffer(g, numSlots, 43, 0)
+	results := g.NewIntBuffer(numSlots, g.NewInt(1))
+
+	err := cpuBackend{}.Mul2Chunk(context.Background(), nil, g, x, y, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := uint32(0); i < numSlots; i++ {
+		expected := cryptops.Mul2(g, x.Get(i), y.Get(i).DeepCopy())
+		if expected.Cmp(results.Get(i)) != 0 {
+			t.Errorf("Go results (%+v) didn't match Mul2Chunk results (%+v) in slot %v",
+				expected.Text(16), results.Get(i).Text(16), i)
+		}
+	}
+}
+
+// CPU Mul2Chunk results should match cryptops.Mul2 in every slot when the
+// operands are slices
+func TestCpuBackend_Mul2Chunk_IntSlice(t *testing.T) {
+	const numSlots = 40
+	g := makeTestGroup2048()
+	x := initRandomIntBuffer(g, numSlots, 42, 0)
+	yBuf := initRandomIntBuffer(g, numSlots, 43, 0)
+	y := make([]*cyclic.Int, numSlots)
+	results := make([]*cyclic.Int, numSlots)
+	for i := range y {
+		y[i] = yBuf.Get(uint32(i))
+		results[i] = g.NewInt(1)
+	}
+
+	err := cpuBackend{}.Mul2Chunk(context.Background(), nil, g, x, IntSlice(y), IntSlice(results))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := uint32(0); i < numSlots; i++ {
+		expected := cryptops.Mul2(g, x.Get(i), y[i].DeepCopy())
+		if expected.Cmp(results[i]) != 0 {
+			t.Errorf("Go results (%+v) didn't match Mul2Chunk results (%+v) in slot %v",
+				expected.Text(16), results[i].Text(16), i)
+		}
+	}
+}
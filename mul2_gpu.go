@@ -5,192 +5,89 @@
 // LICENSE file.                                                              //
 ////////////////////////////////////////////////////////////////////////////////
 
-//+build linux,gpu
-
 package gpumaths
 
-/*#cgo LDFLAGS: -Llib -lpowmosm75 -Wl,-rpath -Wl,./lib:/opt/xxnetwork/lib
-  #cgo CFLAGS: -I./cgbnBindings/powm -I/opt/xxnetwork/include
-  #include <powm_odd_export.h>
-*/
-import "C"
 import (
-	jww "github.com/spf13/jwalterweatherman"
+	"context"
 	"gitlab.com/elixxir/crypto/cyclic"
-	"math/rand"
-	"time"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
 )
 
-// mul2_gpu.go contains the CUDA ops for the mul2 operation. mul2(...)
-// performs the actual call into the library and Mul2Chunk implements
-// the streaming interface function called by the server implementation.
-
-const kernelMul2 = C.KERNEL_MUL2
-
-// This interface provides compatibility with the underlying mul2 method
-// Int buffers and slices can both be used to implement this interface
-type intGetter interface {
-	Get(index uint32) *cyclic.Int
-	Len() int
-}
-
-type intSlice []*cyclic.Int
-
-// Implement intGetter with cyclic int slice
-func (s intSlice) Get(index uint32) *cyclic.Int {
-	return s[index]
-}
-
-func (s intSlice) Len() int {
-	return len(s)
-}
+// mul2_gpu.go contains the gpu ops for the mul2 operation. putMul2(...) and
+// importMul2(...) lay out the stream's buffer for the library's kernel, and
+// Mul2Chunk implements the streaming interface function called by the server
+// implementation.
 
 // Mul2Chunk performs the mul2 operation on the cypher and precomputation
 // payloads
 // Precondition: All int buffers must have the same length
-var Mul2Chunk Mul2ChunkPrototype = func(p *StreamPool, g *cyclic.Group,
-	x *cyclic.IntBuffer, y *cyclic.IntBuffer, results *cyclic.IntBuffer) error {
-	// Populate mul2 inputs
-	numSlots := uint32(x.Len())
-
-	// Run kernel on the inputs
-	stream := p.TakeStream()
-	defer p.ReturnStream(stream)
-	env := chooseEnv(g)
-	maxSlotsMul2 := uint32(env.maxSlots(len(stream.cpuData), kernelMul2))
-	if numSlots > maxSlotsMul2 {
-		//panic((numSlots+maxSlotsMul2-1)/maxSlotsMul2)
-		//panic(maxSlotsMul2)
-		jww.WARN.Printf("Running %v kernels for Mul2Chunk. Performance may be degraded", (numSlots+maxSlotsMul2-1)/maxSlotsMul2)
+func (b gpuBackend) Mul2Chunk(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x, y, results Ints) error {
+	err := checkLengths("Mul2Chunk", x.Len(), y.Len(), results.Len())
+	if err != nil {
+		return err
 	}
-	for i := uint32(0); i < numSlots; i += maxSlotsMul2 {
-		sliceEnd := i
-		// Don't slice beyond the end of the input slice
-		if i+maxSlotsMul2 <= numSlots {
-			sliceEnd += maxSlotsMul2
-		} else {
-			sliceEnd = numSlots
-		}
-		err := <-mul2(g, x.GetSubBuffer(i, sliceEnd), y.GetSubBuffer(i, sliceEnd), results.GetSubBuffer(i, sliceEnd), env, stream)
-		if err != nil {
-			return err
-		}
+	err = p.validate("Mul2Chunk", g, elements("x", x), elements("y", y))
+	if err != nil {
+		return err
 	}
-
-	return nil
+	return b.runChunk(ctx, p, g, gpuOp{
+		name:     "Mul2Chunk",
+		kernel:   kernelMul2,
+		numSlots: uint32(x.Len()),
+		put: func(env gpumathsEnv, stream Stream, start, end uint32) {
+			putMul2(g, subInts(x, start, end), subInts(y, start, end), env, stream)
+		},
+		importResults: func(env gpumathsEnv, stream Stream, start, end uint32) {
+			importMul2(g, subInts(results, start, end), env, stream)
+		},
+		cpu: func(start, end uint32) error {
+			return cpuBackend{}.Mul2Chunk(ctx, nil, g, subInts(x, start, end),
+				subInts(y, start, end), subInts(results, start, end))
+		},
+		check: func(slot uint32) func() bool {
+			xSlot, product := x.Get(slot).DeepCopy(), y.Get(slot).DeepCopy()
+			return func() bool {
+				return cryptops.Mul2(g, xSlot, product).Cmp(results.Get(slot)) == 0
+			}
+		},
+	})
 }
 
-var Mul2Slice Mul2SlicePrototype = func(p *StreamPool, g *cyclic.Group, x *cyclic.IntBuffer, y, result []*cyclic.Int) error {
-	// Populate mul2 inputs
+// putMul2 arranges the prime and each slot's operands in the stream's buffer
+func putMul2(g *cyclic.Group, x, y Ints, env gpumathsEnv, stream Stream) {
+	// Arrange memory into stream buffers
 	numSlots := uint32(x.Len())
 
-	// Run kernel on the inputs
-	stream := p.TakeStream()
-	defer p.ReturnStream(stream)
-	env := chooseEnv(g)
-	maxSlotsMul2 := uint32(env.maxSlots(len(stream.cpuData), kernelMul2))
-	for i := uint32(0); i < numSlots; i += maxSlotsMul2 {
-		sliceEnd := i
-		// Don't slice beyond the end of the input slice
-		if i+maxSlotsMul2 <= numSlots {
-			sliceEnd += maxSlotsMul2
-		} else {
-			sliceEnd = numSlots
-		}
-		err := <-mul2(g, x.GetSubBuffer(i, sliceEnd), intSlice(y[i:sliceEnd]), intSlice(result[i:sliceEnd]), env, stream)
-		if err != nil {
-			return err
-		}
+	// TODO clean this up by implementing the
+	// arrangement/dearrangement with reader/writer interfaces
+	// or smth
+	constants := stream.getCpuConstantsWords(env, kernelMul2)
+	bnLengthWords := env.getWordLen()
+	putBits(constants, g.GetP().Bits(), bnLengthWords)
+
+	inputs := stream.getCpuInputsWords(env, kernelMul2, int(numSlots))
+	offset := 0
+	for i := uint32(0); i < numSlots; i++ {
+		// Put the first operand for this slot
+		putBits(inputs[offset:offset+bnLengthWords], x.Get(i).Bits(), bnLengthWords)
+		offset += bnLengthWords
+		// Put the second operand for this slot
+		putBits(inputs[offset:offset+bnLengthWords], y.Get(i).Bits(), bnLengthWords)
+		offset += bnLengthWords
 	}
-
-	return nil
 }
 
-// mul2 runs the mul2 operation on precomputation and cypher payloads inside
-// the GPU
-// NOTE: publicCypherKey and prime should be byte slices obtained by running
-//       .Bytes() on the large int
-// The resulting byte slice should be trimmed and should be less than or
-// equal to the length of the template-instantiated BN on the GPU.
-// bnLength is a length in bits
-// puts output in results int buffer
-func mul2(g *cyclic.Group, x intGetter, y intGetter, results intGetter, env gpumathsEnv, stream Stream) chan error {
-	debugPrint := false
-	callId := rand.Intn(9999)
-	start := time.Now()
-	//if debugPrint {
-	//	println("starting mul2 call", callId, "with", len(input.Slots), "slots at", start.String())
-	//}
-	start = time.Now()
-	//Return the result later, when the GPU job finishes
-	resultChan := make(chan error, 1)
-	go func() {
-		// Arrange memory into stream buffers
-		numSlots := uint32(x.Len())
+// importMul2 copies each slot's product out of the stream's buffer
+func importMul2(g *cyclic.Group, results Ints, env gpumathsEnv, stream Stream) {
+	numSlots := uint32(results.Len())
+	bnLengthWords := env.getWordLen()
+	outputs := stream.getCpuOutputsWords(env, kernelMul2, int(numSlots))
 
-		// TODO clean this up by implementing the
-		// arrangement/dearrangement with reader/writer interfaces
-		// or smth
-		constants := stream.getCpuConstantsWords(env, kernelMul2)
-		bnLengthWords := env.getWordLen()
-		putBits(constants, g.GetP().Bits(), bnLengthWords)
-		offset := 0
+	offset := 0
+	for i := uint32(0); i < numSlots; i++ {
+		// Output the computed result into each slot
+		g.OverwriteBits(results.Get(i), outputs[offset:offset+bnLengthWords])
 		offset += bnLengthWords
-
-		inputs := stream.getCpuInputsWords(env, kernelMul2, int(numSlots))
-		offset = 0
-		for i := uint32(0); i < numSlots; i++ {
-			// Put the first operand for this slot
-			putBits(inputs[offset:offset+bnLengthWords], x.Get(i).Bits(), bnLengthWords)
-			offset += bnLengthWords
-			// Put the second operand for this slot
-			putBits(inputs[offset:offset+bnLengthWords], y.Get(i).Bits(), bnLengthWords)
-			offset += bnLengthWords
-		}
-		if debugPrint {
-			println("Call", callId, "post input arrangement", time.Since(start))
-			start = time.Now()
-		}
-
-		// Upload, run, wait for download
-		err := env.enqueue(stream, kernelMul2, int(numSlots))
-		if debugPrint {
-			println("Call", callId, "post put", time.Since(start))
-			start = time.Now()
-		}
-		if err != nil {
-			resultChan <- err
-			return
-		}
-
-		outputs := stream.getCpuOutputsWords(env, kernelMul2, int(numSlots))
-
-		// Wait on things to finish with Cuda
-		err = get(stream)
-
-		if debugPrint {
-			println("Call", callId, "post get", time.Since(start))
-			start = time.Now()
-		}
-		if err != nil {
-			resultChan <- err
-			return
-		}
-
-		// Everything is OK, so let's go ahead and import the results
-
-		offset = 0
-		for i := uint32(0); i < numSlots; i++ {
-			// Output the computed result into each slot
-			g.OverwriteBits(results.Get(i), outputs[offset:offset+bnLengthWords])
-			offset += bnLengthWords
-		}
-
-		if debugPrint {
-			println("Call", callId, "post output arrangement", time.Since(start))
-		}
-
-		resultChan <- nil
-	}()
-	return resultChan
+	}
 }
@@ -7,11 +7,67 @@
 
 package gpumaths
 
-import "gitlab.com/elixxir/crypto/cyclic"
+import (
+	"context"
+	"gitlab.com/elixxir/crypto/cyclic"
+)
 
 type Mul3ChunkPrototype func(p *StreamPool, g *cyclic.Group,
 	x, y, z, result *cyclic.IntBuffer) error
 
+// Mul3Chunk performs the mul3 operation on the cypher and precomputation
+// payloads. It runs on the active backend.
+// All int buffers must have the same length, or ErrBufferLengthMismatch is
+// returned
+var Mul3Chunk Mul3ChunkPrototype = func(p *StreamPool, g *cyclic.Group,
+	x, y, z, results *cyclic.IntBuffer) error {
+	return Mul3ChunkContext(context.Background(), p, g, x, y, z, results)
+}
+
+// Mul3ChunkContext is Mul3Chunk, but it takes any Ints, and it stops between
+// kernels (or slots, on the CPU) once ctx is done, and returns ctx.Err().
+// Slots that hadn't been computed yet are left as they were.
+func Mul3ChunkContext(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x, y, z, results Ints) error {
+	return ActiveBackend().Mul3Chunk(ctx, p, g, x, y, z, results)
+}
+
+// Mul3ChunkAsync is Mul3ChunkContext, but it runs in the background.
+// The outputs can be used once the returned handle is done.
+func Mul3ChunkAsync(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x, y, z, results Ints) *ChunkHandle {
+	b := ActiveBackend()
+	return goChunk(func() error {
+		return b.Mul3Chunk(ctx, p, g, x, y, z, results)
+	})
+}
+
+// Mul3SlicePrototype is Mul3ChunkPrototype for slices of cyclic ints
+type Mul3SlicePrototype func(p *StreamPool, g *cyclic.Group,
+	x, y, z, results []*cyclic.Int) error
+
+// Mul3Slice is Mul3Chunk, but it takes slices of cyclic ints instead of int
+// buffers
+var Mul3Slice Mul3SlicePrototype = func(p *StreamPool, g *cyclic.Group,
+	x, y, z, results []*cyclic.Int) error {
+	return Mul3SliceContext(context.Background(), p, g, x, y, z, results)
+}
+
+// Mul3SliceContext is Mul3Slice, but it stops between kernels (or slots, on
+// the CPU) once ctx is done, and returns ctx.Err(). Slots that hadn't been
+// computed yet are left as they were.
+func Mul3SliceContext(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x, y, z, results []*cyclic.Int) error {
+	return Mul3ChunkContext(ctx, p, g, IntSlice(x), IntSlice(y), IntSlice(z), IntSlice(results))
+}
+
+// Mul3SliceAsync is Mul3SliceContext, but it runs in the background.
+// The outputs can be used once the returned handle is done.
+func Mul3SliceAsync(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x, y, z, results []*cyclic.Int) *ChunkHandle {
+	return Mul3ChunkAsync(ctx, p, g, IntSlice(x), IntSlice(y), IntSlice(z), IntSlice(results))
+}
+
 // GetInputSize is how big chunk sizes should be to run the mul3 operation
 func (Mul3ChunkPrototype) GetInputSize() uint32 {
 	return 256
@@ -20,3 +76,12 @@ func (Mul3ChunkPrototype) GetInputSize() uint32 {
 func (Mul3ChunkPrototype) GetName() string {
 	return "Mul3Chunk"
 }
+
+// GetInputSize is how big chunk sizes should be to run the mul3 operation
+func (Mul3SlicePrototype) GetInputSize() uint32 {
+	return 256
+}
+
+func (Mul3SlicePrototype) GetName() string {
+	return "Mul3Slice"
+}
@@ -5,17 +5,36 @@
 // LICENSE file.                                                              //
 ////////////////////////////////////////////////////////////////////////////////
 
-//+build !linux !gpu
-
 package gpumaths
 
 import (
-	"github.com/pkg/errors"
+	"context"
 	"gitlab.com/elixxir/crypto/cyclic"
 )
 
-// Mul3Chunk is stubbed unless GPU is present.
-var Mul3Chunk Mul3ChunkPrototype = func(p *StreamPool, g *cyclic.Group,
-	x *cyclic.IntBuffer, y *cyclic.IntBuffer, z *cyclic.IntBuffer, result *cyclic.IntBuffer) error {
-	return errors.New(NoGpuErrStr)
+// Mul3Chunk performs the mul3 operation on the CPU, so
+// results[i] = x[i]*y[i]*z[i] mod p. Slots are computed in parallel across
+// all available cores.
+// Precondition: All int buffers must have the same length
+func (cpuBackend) Mul3Chunk(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	x, y, z, results Ints) error {
+	err := checkLengths("Mul3Chunk", x.Len(), y.Len(), z.Len(), results.Len())
+	if err != nil {
+		return err
+	}
+	err = p.validate("Mul3Chunk", g, elements("x", x), elements("y", y), elements("z", z))
+	if err != nil {
+		return err
+	}
+	numSlots := uint32(x.Len())
+	release, err := p.acquire(ctx, "Mul3Chunk", numSlots)
+	if err != nil {
+		return err
+	}
+	return release(forEachSlotContext(ctx, numSlots, func(i uint32) {
+		// Like the kernel, read every input before writing the result, in
+		// case the result buffer is also one of the inputs
+		tmp := g.Mul(x.Get(i), y.Get(i), g.NewInt(1))
+		g.Mul(tmp, z.Get(i), results.Get(i))
+	}))
 }
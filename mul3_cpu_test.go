@@ -0,0 +1,40 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
+	"testing"
+)
+
+// CPU Mul3Chunk results should match cryptops.Mul3 in every slot, even when
+// the results are written over one of the inputs
+func TestCpuBackend_Mul3Chunk(t *testing.T) {
+	const numSlots = 40
+	g := makeTestGroup2048()
+	x := initRandomIntBuffer(g, numSlots, 42, 0)
+	y := initRandomIntBuffer(g, numSlots, 43, 0)
+	z := initRandomIntBuffer(g, numSlots, 44, 0)
+	expected := z.DeepCopy()
+	for i := uint32(0); i < numSlots; i++ {
+		cryptops.Mul3(g, x.Get(i), y.Get(i), expected.Get(i))
+	}
+
+	err := cpuBackend{}.Mul3Chunk(context.Background(), nil, g, x, y, z, z)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := uint32(0); i < numSlots; i++ {
+		if expected.Get(i).Cmp(z.Get(i)) != 0 {
+			t.Errorf("Go results (%+v) didn't match Mul3Chunk results (%+v) in slot %v",
+				expected.Get(i).Text(16), z.Get(i).Text(16), i)
+		}
+	}
+}
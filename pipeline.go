@@ -0,0 +1,130 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+)
+
+// pipeline.go contains the pipelined mode of the gpu chunk functions. A
+// chunk is split into batches that alternate between two streams, so while
+// one stream runs the kernel for a batch, the host unpacks the results of
+// the batch before it from the other stream and packs the batch after it.
+
+// How many batches a chunk gets split into if the policy doesn't say
+const defaultPipelineBatches = 4
+
+// PipelinePolicy decides whether chunks run on the GPU get pipelined. The
+// zero value runs each kernel only once the host is done with the last one.
+type PipelinePolicy struct {
+	// Pipeline chunks on pairs of streams
+	Enabled bool
+	// Most slots in each batch. Zero splits each chunk into a few batches.
+	// Batches never have more slots than fit in a stream.
+	BatchSlots int
+}
+
+// SetPipelinePolicy sets whether chunks run on the pool's streams get
+// pipelined
+func (sm *StreamPool) SetPipelinePolicy(policy PipelinePolicy) {
+	sm.mux.Lock()
+	sm.pipelinePolicy = policy
+	sm.mux.Unlock()
+}
+
+// GetPipelinePolicy returns whether chunks get pipelined
+func (sm *StreamPool) GetPipelinePolicy() PipelinePolicy {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	return sm.pipelinePolicy
+}
+
+// pipelineBatchSlots returns how many slots go in each batch of a pipelined
+// chunk, when at most maxSlots fit in a stream
+func (sm *StreamPool) pipelineBatchSlots(numSlots uint32, maxSlots uint32) uint32 {
+	batchSlots := uint32(sm.GetPipelinePolicy().BatchSlots)
+	if batchSlots == 0 {
+		batchSlots = (numSlots + defaultPipelineBatches - 1) / defaultPipelineBatches
+	}
+	if batchSlots == 0 {
+		batchSlots = 1
+	}
+	if batchSlots > maxSlots {
+		batchSlots = maxSlots
+	}
+	return batchSlots
+}
+
+// runPipelined runs kernels from the queue on a pair of streams until there
+// are none left or ctx is done, then gives the streams back. Batches
+// alternate between the streams, and a batch's results are imported just
+// before the next batch is put in the same stream.
+func runPipelined(ctx context.Context, p *StreamPool, env gpumathsEnv, op gpuOp,
+	streams [2]Stream, k *kernelQueue) error {
+	type batch struct {
+		start, end uint32
+		done       chan error
+	}
+	var inFlight [2]*batch
+	defer func() {
+		for i := range streams {
+			if inFlight[i] == nil {
+				p.ReturnStream(streams[i])
+			} else {
+				// The batch's results are abandoned
+				returnWhenDone(p, streams[i], inFlight[i].done)
+			}
+		}
+	}()
+
+	// finish waits for the batch running on a stream and imports its results
+	finish := func(i int) error {
+		b := inFlight[i]
+		var err error
+		select {
+		case err = <-b.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		inFlight[i] = nil
+		if err != nil {
+			return k.kernelFailed(p, op, streams[i], b.start, b.end, err)
+		}
+		p.kernelSucceeded(streams[i])
+		op.importResults(env, streams[i], b.start, b.end)
+		return nil
+	}
+
+	i := 0
+	for ; ; i ^= 1 {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// The other stream's kernel keeps running while this one is emptied
+		// and refilled
+		if inFlight[i] != nil {
+			err := finish(i)
+			if err != nil {
+				return err
+			}
+		}
+		start, end, ok := k.claim()
+		if !ok {
+			break
+		}
+		op.put(env, streams[i], start, end)
+		p.recordLaunch(op.name, end-start)
+		inFlight[i] = &batch{start: start, end: end,
+			done: startKernel(env, streams[i], op.kernel, int(end-start))}
+	}
+	// The last batch to start is on the other stream
+	if inFlight[i^1] != nil {
+		return finish(i ^ 1)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"bytes"
+	"context"
+	"github.com/pkg/errors"
+	"gitlab.com/elixxir/crypto/cyclic"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Long enough that the host's work on a batch always finishes before the
+// kernel before it
+const pipelineTestLatency = 20 * time.Millisecond
+
+// kernelLog makes the test device's kernels take a while to come back. Like
+// a real GPU, it runs one kernel at a time, in the order they're enqueued. It
+// keeps a log of the kernels, and fails any kernel whose inputs are changed
+// while it's running.
+type kernelLog struct {
+	sync.Mutex
+	// Inputs of the kernel running on each stream, by stream id
+	running map[int][]byte
+	// When the kernel running on each stream finishes, by stream id
+	finishes map[int]time.Time
+	// When the last kernel to be enqueued finishes
+	lastFinish time.Time
+	// Most kernels that were ever running at once
+	maxRunning int
+	// Kernels in the order they were enqueued
+	enqueued []int
+	// Number of kernels that had come back when each was enqueued
+	gotBefore []int
+	numGot    int
+}
+
+func newKernelLog() *kernelLog {
+	return &kernelLog{
+		running:  make(map[int][]byte),
+		finishes: make(map[int]time.Time),
+	}
+}
+
+// hooks log the kernels of a test device running on primes like g's
+func (l *kernelLog) hooks(t *testing.T, g *cyclic.Group) testHooks {
+	env := mustChooseEnv(t, emulatedDevice{}, g.GetP().BitLen())
+	return testHooks{
+		onEnqueue: func(stream Stream, k kernel, numSlots int) {
+			// Constants and inputs of the kernel on the stream
+			inputs := stream.cpuData[:env.getConstantsSize(k)+env.getInputSize(k)*numSlots]
+			l.enqueue(stream, inputs)
+		},
+		onGet: l.get,
+	}
+}
+
+func (l *kernelLog) enqueue(stream Stream, inputs []byte) {
+	l.Lock()
+	defer l.Unlock()
+	l.running[stream.id] = append([]byte(nil), inputs...)
+	if len(l.running) > l.maxRunning {
+		l.maxRunning = len(l.running)
+	}
+	start := time.Now()
+	if start.Before(l.lastFinish) {
+		start = l.lastFinish
+	}
+	l.lastFinish = start.Add(pipelineTestLatency)
+	l.finishes[stream.id] = l.lastFinish
+	l.enqueued = append(l.enqueued, stream.id)
+	l.gotBefore = append(l.gotBefore, l.numGot)
+}
+
+func (l *kernelLog) get(stream Stream) error {
+	l.Lock()
+	finish := l.finishes[stream.id]
+	l.Unlock()
+	time.Sleep(time.Until(finish))
+	l.Lock()
+	inputs := l.running[stream.id]
+	delete(l.running, stream.id)
+	l.numGot++
+	l.Unlock()
+	if !bytes.Equal(inputs, stream.cpuData[:len(inputs)]) {
+		return errors.Errorf("stream %v's inputs changed while its kernel ran", stream.id)
+	}
+	return nil
+}
+
+// newPipelinedPool makes a pool of two streams on a test device that logs
+// its kernels, and pipelines batches of batchSlots slots
+func newPipelinedPool(t *testing.T, g *cyclic.Group, k kernel, batchSlots int) (gpuBackend, *StreamPool, *kernelLog) {
+	log := newKernelLog()
+	b, pool := newTestPool(t, g, k, 2, log.hooks(t, g))
+	pool.SetPipelinePolicy(PipelinePolicy{Enabled: true, BatchSlots: batchSlots})
+	return b, pool, log
+}
+
+// Checks that batches alternated between the two streams, and that each
+// batch was enqueued while the one before it was still running
+func checkPipelined(t *testing.T, log *kernelLog, numBatches int) {
+	log.Lock()
+	defer log.Unlock()
+	if len(log.enqueued) != numBatches {
+		t.Fatalf("expected %v batches, got %v", numBatches, len(log.enqueued))
+	}
+	if log.maxRunning != 2 {
+		t.Errorf("two batches should have been running at once, got %v", log.maxRunning)
+	}
+	for n := 1; n < numBatches; n++ {
+		if log.enqueued[n] == log.enqueued[n-1] {
+			t.Errorf("batches %v and %v both ran on stream %v", n-1, n, log.enqueued[n])
+		}
+		// Batch n-1 can't have come back yet
+		if log.gotBefore[n] > n-1 {
+			t.Errorf("batch %v was only enqueued once batch %v came back", n, n-1)
+		}
+	}
+}
+
+func TestPipeline_ExpChunk(t *testing.T) {
+	g := makeTestGroup2048()
+	b, pool, log := newPipelinedPool(t, g, kernelPowmOdd, 2)
+	x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+	y := initRandomIntBuffer(g, emulatedNumSlots, 2, 32)
+	z := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+
+	_, err := b.ExpChunk(context.Background(), pool, g, x, y, z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+	for i := uint32(0); i < emulatedNumSlots; i++ {
+		cryptops.Exp(g, x.Get(i), y.Get(i), expected.Get(i))
+	}
+	checkSlots(t, expected, z)
+	// 7 slots in batches of 2
+	checkPipelined(t, log, 4)
+}
+
+// ElGamal reads and writes the same buffers, so each batch has to be
+// imported into the right slots
+func TestPipeline_ElGamalChunk(t *testing.T) {
+	g := makeTestGroup2048()
+	b, pool, log := newPipelinedPool(t, g, kernelElgamal, 1)
+	key := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+	privateKey := initRandomIntBuffer(g, emulatedNumSlots, 2, 32)
+	publicCypherKey := initRandomIntBuffer(g, 1, 3, 0).Get(0)
+	ecrKey := initRandomIntBuffer(g, emulatedNumSlots, 4, 0)
+	cypher := initRandomIntBuffer(g, emulatedNumSlots, 5, 0)
+	expectedEcrKey := ecrKey.DeepCopy()
+	expectedCypher := cypher.DeepCopy()
+
+	err := b.ElGamalChunk(context.Background(), pool, g, key, privateKey, publicCypherKey, ecrKey, cypher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(0); i < emulatedNumSlots; i++ {
+		cryptops.ElGamal(g, key.Get(i), privateKey.Get(i), publicCypherKey,
+			expectedEcrKey.Get(i), expectedCypher.Get(i))
+	}
+	checkSlots(t, expectedEcrKey, ecrKey)
+	checkSlots(t, expectedCypher, cypher)
+	checkPipelined(t, log, emulatedNumSlots)
+}
+
+// With only one stream there's nothing to pipeline with, so the chunk runs
+// in kernels that fill the stream, and counts as split
+func TestPipeline_OneStream(t *testing.T) {
+	g := makeTestGroup2048()
+	log := newKernelLog()
+	b, pool := newTestPool(t, g, kernelPowmOdd, 1, log.hooks(t, g))
+	pool.SetPipelinePolicy(PipelinePolicy{Enabled: true, BatchSlots: 1})
+	x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+	y := initRandomIntBuffer(g, emulatedNumSlots, 2, 32)
+	z := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+
+	_, err := b.ExpChunk(context.Background(), pool, g, x, y, z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+	for i := uint32(0); i < emulatedNumSlots; i++ {
+		cryptops.Exp(g, x.Get(i), y.Get(i), expected.Get(i))
+	}
+	checkSlots(t, expected, z)
+	// 7 slots in kernels of 3, not batches of 1
+	log.Lock()
+	numKernels := len(log.enqueued)
+	log.Unlock()
+	if numKernels != 3 {
+		t.Errorf("expected 3 kernels, got %v", numKernels)
+	}
+	if splits := pool.Stats().Splits; splits != 1 {
+		t.Errorf("expected the chunk to be counted as split, got %v splits", splits)
+	}
+}
+
+// Without a batch size, chunks are split into a few batches, which are never
+// bigger than a stream
+func TestStreamPool_PipelineBatchSlots(t *testing.T) {
+	pool, err := cpuBackend{}.NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.SetPipelinePolicy(PipelinePolicy{Enabled: true})
+	if n := pool.pipelineBatchSlots(100, 1000); n != 25 {
+		t.Errorf("expected batches of 25, got %v", n)
+	}
+	if n := pool.pipelineBatchSlots(100, 10); n != 10 {
+		t.Errorf("batches should fit in a stream, got %v", n)
+	}
+	if n := pool.pipelineBatchSlots(1, 10); n != 1 {
+		t.Errorf("expected a batch of 1, got %v", n)
+	}
+	pool.SetPipelinePolicy(PipelinePolicy{Enabled: true, BatchSlots: 7})
+	if n := pool.pipelineBatchSlots(100, 10); n != 7 {
+		t.Errorf("expected the policy's batch size, got %v", n)
+	}
+}
@@ -0,0 +1,164 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"fmt"
+)
+
+// priority.go contains the priority classes that callers wait for streams
+// at. When streams are scarce, realtime work gets them before precomputation,
+// and precomputation before background work.
+
+// Priority is how urgently a caller needs a stream. Lower values are more
+// urgent.
+type Priority int
+
+const (
+	// Realtime work holds up a round, so it's served first
+	PriorityRealtime Priority = iota
+	// Precomputation is what callers get if they don't say
+	PriorityPrecomputation
+	PriorityBackground
+	numPriorities
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityRealtime:
+		return "realtime"
+	case PriorityPrecomputation:
+		return "precomputation"
+	case PriorityBackground:
+		return "background"
+	default:
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a context that takes streams at the given priority.
+// Pass it to the Context chunk functions to run them at that priority.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext returns the priority a context was given with
+// WithPriority, or PriorityPrecomputation if it wasn't given one
+func PriorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return PriorityPrecomputation
+}
+
+// How many times a waiting caller can be passed over if the policy doesn't
+// say
+const defaultMaxPassedOver = 4
+
+// PriorityPolicy protects less urgent callers from waiting forever while
+// more urgent ones keep arriving
+type PriorityPolicy struct {
+	// How many times a stream can go to a more urgent caller while a caller
+	// is first in line for its priority. After that, it's served next.
+	// Zero uses the default.
+	MaxPassedOver int
+}
+
+// SetPriorityPolicy sets how long less urgent callers can be passed over
+func (sm *StreamPool) SetPriorityPolicy(policy PriorityPolicy) {
+	sm.mux.Lock()
+	sm.priorityPolicy = policy
+	sm.mux.Unlock()
+}
+
+// GetPriorityPolicy returns how long less urgent callers can be passed over
+func (sm *StreamPool) GetPriorityPolicy() PriorityPolicy {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	return sm.priorityPolicy
+}
+
+// waiter is a caller waiting for a stream
+type waiter struct {
+	priority Priority
+	// Receives the stream once it's checked out to the waiter
+	stream chan Stream
+	// How many streams went to more urgent callers while this was first in
+	// line for its priority
+	passedOver int
+}
+
+// hasWaiters returns whether anything is waiting for a stream. mux must be
+// held.
+func (sm *StreamPool) hasWaiters() bool {
+	for p := range sm.waiters {
+		if len(sm.waiters[p]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// wait puts a new waiter at the back of the line for its priority. mux must
+// be held.
+func (sm *StreamPool) wait(p Priority) *waiter {
+	w := &waiter{priority: p, stream: make(chan Stream, 1)}
+	sm.waiters[p] = append(sm.waiters[p], w)
+	return w
+}
+
+// removeWaiter takes a waiter out of line, returning false if it had already
+// been served. mux must be held.
+func (sm *StreamPool) removeWaiter(w *waiter) bool {
+	line := sm.waiters[w.priority]
+	for i := range line {
+		if line[i] == w {
+			sm.waiters[w.priority] = append(line[:i:i], line[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// nextWaiter takes the waiter that should get the next stream out of line,
+// or returns nil if nothing is waiting. That's the first in line of the most
+// urgent priority, unless the first in line of a less urgent priority has
+// been passed over too many times. mux must be held.
+func (sm *StreamPool) nextWaiter() *waiter {
+	maxPassedOver := sm.priorityPolicy.MaxPassedOver
+	if maxPassedOver <= 0 {
+		maxPassedOver = defaultMaxPassedOver
+	}
+	next := Priority(-1)
+	for p := range sm.waiters {
+		if len(sm.waiters[p]) == 0 {
+			continue
+		}
+		if next == -1 {
+			next = Priority(p)
+		}
+		if sm.waiters[p][0].passedOver >= maxPassedOver {
+			next = Priority(p)
+			break
+		}
+	}
+	if next == -1 {
+		return nil
+	}
+	w := sm.waiters[next][0]
+	sm.waiters[next] = sm.waiters[next][1:]
+	for p := next + 1; p < numPriorities; p++ {
+		if len(sm.waiters[p]) > 0 {
+			sm.waiters[p][0].passedOver++
+		}
+	}
+	return w
+}
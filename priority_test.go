@@ -0,0 +1,154 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// served is a stream that a named waiter got
+type served struct {
+	name   string
+	stream Stream
+}
+
+// priorityLine runs waiters on a pool with one stream, which the test holds
+// between turns
+type priorityLine struct {
+	t      *testing.T
+	pool   *StreamPool
+	served chan served
+}
+
+func newPriorityLine(t *testing.T) (*priorityLine, Stream) {
+	pool, err := cpuBackend{}.NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &priorityLine{t: t, pool: pool, served: make(chan served)}, pool.TakeStream()
+}
+
+func (l *priorityLine) numWaiting() int {
+	l.pool.mux.Lock()
+	defer l.pool.mux.Unlock()
+	n := 0
+	for p := range l.pool.waiters {
+		n += len(l.pool.waiters[p])
+	}
+	return n
+}
+
+// wait starts a waiter and returns once it's in line, so waiters get in line
+// in the order they're started
+func (l *priorityLine) wait(ctx context.Context, name string, p Priority) {
+	before := l.numWaiting()
+	go func() {
+		s, err := l.pool.TakeStreamPriority(ctx, p)
+		if err == nil {
+			l.served <- served{name: name, stream: s}
+		}
+	}()
+	for deadline := time.Now().Add(time.Second); l.numWaiting() == before; {
+		if time.Now().After(deadline) {
+			l.t.Fatalf("%v never started waiting", name)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// next gives the stream back and returns who got it
+func (l *priorityLine) next(s Stream) served {
+	l.pool.ReturnStream(s)
+	select {
+	case next := <-l.served:
+		return next
+	case <-time.After(time.Second):
+		l.t.Fatal("the returned stream didn't go to anyone")
+		return served{}
+	}
+}
+
+// Returned streams go to the most urgent waiter, and to the first in line
+// within a priority
+func TestStreamPool_PriorityOrder(t *testing.T) {
+	l, s := newPriorityLine(t)
+	ctx := context.Background()
+	l.wait(ctx, "background", PriorityBackground)
+	l.wait(ctx, "precomputation 1", PriorityPrecomputation)
+	l.wait(ctx, "realtime", PriorityRealtime)
+	l.wait(ctx, "precomputation 2", PriorityPrecomputation)
+
+	expected := []string{"realtime", "precomputation 1", "precomputation 2", "background"}
+	for _, name := range expected {
+		next := l.next(s)
+		if next.name != name {
+			t.Errorf("expected %v to get the stream, but %v did", name, next.name)
+		}
+		s = next.stream
+	}
+}
+
+// A background waiter is served once realtime waiters have been served ahead
+// of it MaxPassedOver times
+func TestStreamPool_PriorityStarvation(t *testing.T) {
+	l, s := newPriorityLine(t)
+	l.pool.SetPriorityPolicy(PriorityPolicy{MaxPassedOver: 2})
+	ctx := context.Background()
+	l.wait(ctx, "background", PriorityBackground)
+	l.wait(ctx, "realtime 1", PriorityRealtime)
+	l.wait(ctx, "realtime 2", PriorityRealtime)
+	l.wait(ctx, "realtime 3", PriorityRealtime)
+
+	expected := []string{"realtime 1", "realtime 2", "background", "realtime 3"}
+	for _, name := range expected {
+		next := l.next(s)
+		if next.name != name {
+			t.Errorf("expected %v to get the stream, but %v did", name, next.name)
+		}
+		s = next.stream
+	}
+}
+
+// The priority can come from the context, and a waiter that gives up leaves
+// the line
+func TestStreamPool_PriorityContext(t *testing.T) {
+	l, s := newPriorityLine(t)
+	ctx, cancel := context.WithCancel(WithPriority(context.Background(), PriorityRealtime))
+	if PriorityFromContext(ctx) != PriorityRealtime {
+		t.Errorf("expected the context's priority to be realtime")
+	}
+	if PriorityFromContext(context.Background()) != PriorityPrecomputation {
+		t.Errorf("a context without a priority should take streams for precomputation")
+	}
+
+	l.wait(context.Background(), "precomputation", PriorityPrecomputation)
+	l.wait(ctx, "cancelled", PriorityRealtime)
+	cancel()
+	for deadline := time.Now().Add(time.Second); l.numWaiting() != 1; {
+		if time.Now().After(deadline) {
+			t.Fatal("the cancelled waiter should have left the line")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if next := l.next(s); next.name != "precomputation" {
+		t.Errorf("expected precomputation to get the stream, but %v did", next.name)
+	}
+}
+
+func TestStreamPool_UnknownPriority(t *testing.T) {
+	pool, err := cpuBackend{}.NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pool.TakeStreamPriority(context.Background(), numPriorities)
+	if err == nil {
+		t.Error("taking a stream at an unknown priority should be an error")
+	}
+}
@@ -7,17 +7,74 @@
 
 package gpumaths
 
-import "gitlab.com/elixxir/crypto/cyclic"
+import (
+	"context"
+	"gitlab.com/elixxir/crypto/cyclic"
+)
 
 // reveal.go contains the input, results, and other types for running the reveal
-// operation against the GPU. The actual GPU call is in reveal_gpu.go and is
-// marked to require `-tags gpu` in your build.
+// operation on the active backend. The actual GPU call is in reveal_gpu.go and
+// runs on CUDA when built with `-tags gpu`. The CPU version is in
+// reveal_cpu.go.
 
 // RevealChunkPrototype defines the function type for running the reveal
 // kernel in the GPU.
 type RevealChunkPrototype func(p *StreamPool, g *cyclic.Group,
 	publicCypherKey *cyclic.Int, cypher *cyclic.IntBuffer, result *cyclic.IntBuffer) error
 
+// RevealChunk performs the reveal operation on the cypher payloads. It runs
+// on the active backend.
+// All int buffers must have the same length, or ErrBufferLengthMismatch is
+// returned
+var RevealChunk RevealChunkPrototype = func(p *StreamPool, g *cyclic.Group,
+	publicCypherKey *cyclic.Int, cypher *cyclic.IntBuffer, result *cyclic.IntBuffer) error {
+	return RevealChunkContext(context.Background(), p, g, publicCypherKey, cypher, result)
+}
+
+// RevealChunkContext is RevealChunk, but it takes any Ints, and it stops
+// between kernels (or slots, on the CPU) once ctx is done, and returns
+// ctx.Err(). Slots that hadn't been computed yet are left as they were.
+func RevealChunkContext(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	publicCypherKey *cyclic.Int, cypher, result Ints) error {
+	return ActiveBackend().RevealChunk(ctx, p, g, publicCypherKey, cypher, result)
+}
+
+// RevealChunkAsync is RevealChunkContext, but it runs in the background.
+// The outputs can be used once the returned handle is done.
+func RevealChunkAsync(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	publicCypherKey *cyclic.Int, cypher, result Ints) *ChunkHandle {
+	b := ActiveBackend()
+	return goChunk(func() error {
+		return b.RevealChunk(ctx, p, g, publicCypherKey, cypher, result)
+	})
+}
+
+// RevealSlicePrototype is RevealChunkPrototype for slices of cyclic ints
+type RevealSlicePrototype func(p *StreamPool, g *cyclic.Group,
+	publicCypherKey *cyclic.Int, cypher, result []*cyclic.Int) error
+
+// RevealSlice is RevealChunk, but it takes slices of cyclic ints instead of
+// int buffers
+var RevealSlice RevealSlicePrototype = func(p *StreamPool, g *cyclic.Group,
+	publicCypherKey *cyclic.Int, cypher, result []*cyclic.Int) error {
+	return RevealSliceContext(context.Background(), p, g, publicCypherKey, cypher, result)
+}
+
+// RevealSliceContext is RevealSlice, but it stops between kernels (or slots,
+// on the CPU) once ctx is done, and returns ctx.Err(). Slots that hadn't been
+// computed yet are left as they were.
+func RevealSliceContext(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	publicCypherKey *cyclic.Int, cypher, result []*cyclic.Int) error {
+	return RevealChunkContext(ctx, p, g, publicCypherKey, IntSlice(cypher), IntSlice(result))
+}
+
+// RevealSliceAsync is RevealSliceContext, but it runs in the background.
+// The outputs can be used once the returned handle is done.
+func RevealSliceAsync(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	publicCypherKey *cyclic.Int, cypher, result []*cyclic.Int) *ChunkHandle {
+	return RevealChunkAsync(ctx, p, g, publicCypherKey, IntSlice(cypher), IntSlice(result))
+}
+
 // GetInputSize is how big chunk sizes should be to run the reveal operation
 func (RevealChunkPrototype) GetInputSize() uint32 {
 	return 64
@@ -27,3 +84,13 @@ func (RevealChunkPrototype) GetInputSize() uint32 {
 func (RevealChunkPrototype) GetName() string {
 	return "RevealChunk"
 }
+
+// GetInputSize is how big chunk sizes should be to run the reveal operation
+func (RevealSlicePrototype) GetInputSize() uint32 {
+	return 64
+}
+
+// GetName return the name of the RevealSlice operation
+func (RevealSlicePrototype) GetName() string {
+	return "RevealSlice"
+}
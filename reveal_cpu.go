@@ -5,17 +5,52 @@
 // LICENSE file.                                                              //
 ////////////////////////////////////////////////////////////////////////////////
 
-//+build !linux !gpu
-
 package gpumaths
 
 import (
-	"errors"
+	"context"
+	"github.com/pkg/errors"
 	"gitlab.com/elixxir/crypto/cyclic"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
+	"gitlab.com/xx_network/crypto/large"
+	"math/big"
 )
 
-// RevealChunk is stubbed unless GPU is present.
-var RevealChunk RevealChunkPrototype = func(p *StreamPool, g *cyclic.Group,
-	publicCypherKey *cyclic.Int, cypher *cyclic.IntBuffer, result *cyclic.IntBuffer) error {
-	return errors.New(NoGpuErrStr)
+// RevealChunk performs the reveal operation on the cypher payloads on the CPU,
+// so result[i] = cypher[i]**(1/publicCypherKey) mod p, with
+// cryptops.RootCoprime. Slots are computed in parallel across all available
+// cores.
+// Precondition: All int buffers must have the same length
+func (cpuBackend) RevealChunk(ctx context.Context, p *StreamPool, g *cyclic.Group,
+	publicCypherKey *cyclic.Int, cypher, result Ints) error {
+	err := checkLengths("RevealChunk", cypher.Len(), result.Len())
+	if err != nil {
+		return err
+	}
+	err = p.validate("RevealChunk", g, constExponent("publicCypherKey", publicCypherKey), elements("cypher", cypher))
+	if err != nil {
+		return err
+	}
+	numSlots := uint32(cypher.Len())
+	release, err := p.acquire(ctx, "RevealChunk", numSlots)
+	if err != nil {
+		return err
+	}
+
+	// RootCoprime has no way to report a key that can't be inverted, so it's
+	// checked once for the whole chunk
+	pSub1 := new(big.Int).Sub(bigFromBits(g.GetP().Bits()), big.NewInt(1))
+	gcd := new(big.Int).GCD(nil, nil, bigFromBits(publicCypherKey.Bits()), pSub1)
+	if gcd.Cmp(big.NewInt(1)) != 0 {
+		return release(errors.New("RevealChunk: publicCypherKey is not coprime with p-1"))
+	}
+
+	return release(forEachSlotContext(ctx, numSlots, func(i uint32) {
+		cryptops.RootCoprime(g, cypher.Get(i), publicCypherKey, result.Get(i))
+	}))
+}
+
+// bigFromBits copies the words of a large int into a new big.Int
+func bigFromBits(b large.Bits) *big.Int {
+	return new(big.Int).SetBits(append(large.Bits(nil), b...))
 }
@@ -0,0 +1,68 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
+	"testing"
+)
+
+// CPU RevealChunk results should match cryptops.RootCoprime in every slot
+func TestCpuBackend_RevealChunk(t *testing.T) {
+	const numSlots = 25
+	g := makeTestGroup2048()
+	publicCypherKey := g.FindSmallCoprimeInverse(g.NewInt(1), 256)
+	cypher := initRandomIntBuffer(g, numSlots, 42, 0)
+	result := g.NewIntBuffer(numSlots, g.NewInt(1))
+
+	err := cpuBackend{}.RevealChunk(context.Background(), nil, g, publicCypherKey, cypher, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := uint32(0); i < numSlots; i++ {
+		expected := cryptops.RootCoprime(g, cypher.Get(i), publicCypherKey, g.NewInt(1))
+		if expected.Cmp(result.Get(i)) != 0 {
+			t.Errorf("Go results (%+v) didn't match RevealChunk results (%+v) in slot %v",
+				expected.Text(16), result.Get(i).Text(16), i)
+		}
+	}
+}
+
+// The reveal can be written back over the cypher payloads
+func TestCpuBackend_RevealChunk_InPlace(t *testing.T) {
+	const numSlots = 5
+	g := makeTestGroup2048()
+	publicCypherKey := g.FindSmallCoprimeInverse(g.NewInt(1), 256)
+	cypher := initRandomIntBuffer(g, numSlots, 42, 0)
+	original := cypher.DeepCopy()
+
+	err := cpuBackend{}.RevealChunk(context.Background(), nil, g, publicCypherKey, cypher, cypher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := uint32(0); i < numSlots; i++ {
+		expected := cryptops.RootCoprime(g, original.Get(i), publicCypherKey, g.NewInt(1))
+		if expected.Cmp(cypher.Get(i)) != 0 {
+			t.Errorf("in place reveal didn't match Go results in slot %v", i)
+		}
+	}
+}
+
+// A key that has no inverse mod p-1 can't be used to reveal
+func TestCpuBackend_RevealChunk_NotCoprime(t *testing.T) {
+	g := makeTestGroup2048()
+	cypher := initRandomIntBuffer(g, 2, 42, 0)
+	// p-1 is even, so 2 is never coprime with it
+	err := cpuBackend{}.RevealChunk(context.Background(), nil, g, g.NewInt(2), cypher, cypher)
+	if err == nil {
+		t.Error("RevealChunk should have failed with a key that isn't coprime with p-1")
+	}
+}
@@ -0,0 +1,153 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"time"
+)
+
+// stats.go contains the counters that show how a stream pool is being used.
+// The GPU backend counts each kernel it launches, and the CPU backend counts
+// each chunk it computes while holding one of the pool's streams.
+
+// Upper bounds of the buckets in PoolStats.WaitHistogram. Waits longer than
+// the last bound go in one more bucket.
+var waitBounds = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// WaitBucket counts the streams that were taken after waiting for up to
+// UpTo, but longer than the bucket before's UpTo
+type WaitBucket struct {
+	// Zero for the last bucket, which has no upper bound
+	UpTo  time.Duration
+	Count int
+}
+
+// OpStats counts the work done for one of the chunk operations
+type OpStats struct {
+	// Kernels launched on the GPU, or chunks computed on the CPU
+	Launches int
+	// Slots in those kernels or chunks
+	Slots int
+	// How many of them failed
+	Errors int
+}
+
+// PoolStats is a snapshot of a stream pool's use since it was created
+type PoolStats struct {
+	// How many streams the pool has, and how many are checked out
+	Streams, Busy int
+	// Streams that are out of rotation
+	Unhealthy int
+	// How many streams have been taken, how long that took altogether, and
+	// how long each take waited
+	Takes         int
+	TotalWait     time.Duration
+	WaitHistogram []WaitBucket
+	// By the name of the chunk function, e.g. "ExpChunk"
+	Ops map[string]OpStats
+	// Chunks that needed more kernels than there were streams free to run
+	// them all at once, so that some kernels had to wait for others
+	Splits int
+	// Failed kernels or chunks, for all the ops
+	Errors int
+}
+
+// poolStats is what the pool keeps to make PoolStats from
+type poolStats struct {
+	takes     int
+	totalWait time.Duration
+	// Indexed like PoolStats.WaitHistogram
+	waitCounts []int
+	ops        map[string]*OpStats
+	splits     int
+	errors     int
+}
+
+// Stats returns how the pool has been used since it was created
+func (sm *StreamPool) Stats() PoolStats {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	stats := PoolStats{
+		Streams:       len(sm.streams),
+		Busy:          sm.numCheckedOut,
+		Unhealthy:     sm.numUnhealthy,
+		Takes:         sm.stats.takes,
+		TotalWait:     sm.stats.totalWait,
+		WaitHistogram: make([]WaitBucket, len(waitBounds)+1),
+		Ops:           make(map[string]OpStats, len(sm.stats.ops)),
+		Splits:        sm.stats.splits,
+		Errors:        sm.stats.errors,
+	}
+	for i, bound := range waitBounds {
+		stats.WaitHistogram[i].UpTo = bound
+	}
+	for i, count := range sm.stats.waitCounts {
+		stats.WaitHistogram[i].Count = count
+	}
+	for name, op := range sm.stats.ops {
+		stats.Ops[name] = *op
+	}
+	return stats
+}
+
+// recordTake counts a stream that was taken after waiting for wait. mux must
+// be held.
+func (sm *StreamPool) recordTake(wait time.Duration) {
+	sm.stats.takes++
+	sm.stats.totalWait += wait
+	if sm.stats.waitCounts == nil {
+		sm.stats.waitCounts = make([]int, len(waitBounds)+1)
+	}
+	bucket := 0
+	for bucket < len(waitBounds) && wait > waitBounds[bucket] {
+		bucket++
+	}
+	sm.stats.waitCounts[bucket]++
+}
+
+// opStats returns the counters for the named op. mux must be held.
+func (sm *StreamPool) opStats(name string) *OpStats {
+	if sm.stats.ops == nil {
+		sm.stats.ops = make(map[string]*OpStats)
+	}
+	op, ok := sm.stats.ops[name]
+	if !ok {
+		op = &OpStats{}
+		sm.stats.ops[name] = op
+	}
+	return op
+}
+
+// recordLaunch counts a kernel or CPU chunk of numSlots slots
+func (sm *StreamPool) recordLaunch(name string, numSlots uint32) {
+	sm.mux.Lock()
+	op := sm.opStats(name)
+	op.Launches++
+	op.Slots += int(numSlots)
+	sm.mux.Unlock()
+}
+
+// recordError counts a kernel or CPU chunk that failed. mux must be held.
+func (sm *StreamPool) recordError(name string) {
+	sm.opStats(name).Errors++
+	sm.stats.errors++
+}
+
+// recordSplit counts a chunk whose kernels couldn't all run at once
+func (sm *StreamPool) recordSplit() {
+	sm.mux.Lock()
+	sm.stats.splits++
+	sm.mux.Unlock()
+}
@@ -0,0 +1,111 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// The CPU backend counts each chunk once, and the chunks that fail
+func TestStats_CPU(t *testing.T) {
+	g := makeTestGroup2048()
+	pool, err := cpuBackend{}.NewStreamPool(2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := initRandomIntBuffer(g, 5, 1, 0)
+	y := initRandomIntBuffer(g, 5, 2, 0)
+	result := g.NewIntBuffer(5, g.NewInt(1))
+	for i := 0; i < 2; i++ {
+		err = cpuBackend{}.Mul2Chunk(context.Background(), pool, g, x, y, result)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 2 divides p-1, so there's no root to take
+	err = cpuBackend{}.RevealChunk(context.Background(), pool, g, g.NewInt(2), x, result)
+	if err == nil {
+		t.Fatal("RevealChunk should have failed")
+	}
+
+	stats := pool.Stats()
+	if stats.Streams != 2 || stats.Busy != 0 || stats.Takes != 3 {
+		t.Errorf("wrong stream counts: %+v", stats)
+	}
+	if stats.Ops["Mul2Chunk"] != (OpStats{Launches: 2, Slots: 10}) {
+		t.Errorf("wrong Mul2Chunk stats: %+v", stats.Ops["Mul2Chunk"])
+	}
+	if stats.Ops["RevealChunk"] != (OpStats{Launches: 1, Slots: 5, Errors: 1}) || stats.Errors != 1 {
+		t.Errorf("the failed reveal should have been counted, got %+v", stats)
+	}
+}
+
+// The GPU backend counts every kernel, and the chunks that had to wait for
+// streams
+func TestStats_GPU(t *testing.T) {
+	g := makeTestGroup2048()
+	b, pool := newTestPool(t, g, kernelPowmOdd, 1, testHooks{onGet: failCalls(2)})
+	pool.SetFallbackPolicy(FallbackPolicy{UseCPU: true})
+	x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+	y := initRandomIntBuffer(g, emulatedNumSlots, 2, 32)
+	z := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+
+	_, err := b.ExpChunk(context.Background(), pool, g, x, y, z)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := pool.Stats()
+	expected := OpStats{Launches: 3, Slots: emulatedNumSlots, Errors: 1}
+	if stats.Ops["ExpChunk"] != expected {
+		t.Errorf("expected %+v, got %+v", expected, stats.Ops["ExpChunk"])
+	}
+	if stats.Splits != 1 || stats.Errors != 1 {
+		t.Errorf("expected a split and an error, got %+v", stats)
+	}
+}
+
+// Waits should be added up and put in the bucket for their length
+func TestStats_Waits(t *testing.T) {
+	pool, err := cpuBackend{}.NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := pool.TakeStream()
+	taken := make(chan struct{})
+	go func() {
+		pool.ReturnStream(pool.TakeStream())
+		close(taken)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if pool.Stats().Busy != 1 {
+		t.Error("one stream should be checked out")
+	}
+	pool.ReturnStream(s)
+	<-taken
+
+	stats := pool.Stats()
+	if stats.Takes != 2 || stats.TotalWait < 20*time.Millisecond {
+		t.Errorf("expected two takes adding up to at least 20ms, got %+v", stats)
+	}
+	counted := 0
+	for i, bucket := range stats.WaitHistogram {
+		counted += bucket.Count
+		if bucket.Count > 0 && i > 0 && stats.WaitHistogram[i-1].UpTo >= 20*time.Millisecond {
+			t.Errorf("a wait was counted in the bucket up to %v", bucket.UpTo)
+		}
+	}
+	if counted != 2 || stats.WaitHistogram[0].Count != 1 {
+		t.Errorf("expected one short wait and one long one, got %+v", stats.WaitHistogram)
+	}
+	if stats.WaitHistogram[len(stats.WaitHistogram)-1].UpTo != 0 {
+		t.Error("the last bucket shouldn't have an upper bound")
+	}
+}
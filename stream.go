@@ -0,0 +1,398 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"gitlab.com/xx_network/crypto/large"
+	"math/rand"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// stream.go contains the stream pool that every backend hands out streams
+// from. What a stream represents depends on the backend that created the
+// pool: a CUDA stream with its buffers for the GPU backend, or just a worker
+// slot for the CPU backend.
+
+// TODO Functions that currently take a stream as unsafe.Pointer should instead have a stream as the receiver
+type Stream struct {
+	// Identifies the stream within its pool, starting at 1
+	// The zero Stream didn't come from a pool, so it's never returned to one
+	id int
+	// Ordinal of the device the stream was created on
+	device int
+	// Pointer to stream and associated data, usable only on the C side
+	// Nil unless the stream was created by the GPU backend
+	s unsafe.Pointer
+	// This byte slice contains the entire range of the CPU buffer that this stream can use
+	cpuData []byte
+	// Same data but in words!
+	cpuDataWords large.Bits
+}
+
+// Optional improvements:
+//  - create streams with high priority to speed up kernels used for realtime
+type StreamPool struct {
+	// Used to time-bound stream deletion. These are the same streams that you can get from the channel
+	streams []Stream
+	// Releases whatever the backend allocated for the streams
+	destroyStreams func([]Stream) error
+	// How many slots of each op fit in a stream, if the pool was sized for a
+	// workload
+	slotCapacity map[Op]int
+
+	// Closed once the pool starts shutting down, to wake up anything waiting
+	// on a stream
+	closing chan struct{}
+	// Closed once the pool is shutting down and every stream has been returned
+	drained chan struct{}
+
+	// Protects everything below
+	mux sync.Mutex
+	// Which streams are currently checked out, indexed by id-1. Used to make
+	// returning a stream idempotent
+	checkedOut    []bool
+	numCheckedOut int
+	closed        bool
+	isDrained     bool
+	destroyed     bool
+
+	// The streams that are free to take on each of the pool's devices, in
+	// the order they were returned
+	idle [][]Stream
+	// How many streams each device has checked out
+	busy []int
+	// Index of each stream's device in idle and busy, indexed by id-1
+	deviceIndex []int
+
+	// Callers waiting for a stream, by priority, in the order they started
+	// waiting. There are only waiters while no streams are idle.
+	waiters        [numPriorities][]*waiter
+	priorityPolicy PriorityPolicy
+
+	// Whether chunks get pipelined on pairs of streams
+	pipelinePolicy PipelinePolicy
+
+	// What the gpu backend does when a kernel fails
+	fallbackPolicy FallbackPolicy
+	numFallbacks   int
+	lastFallback   Fallback
+	// Each stream's failures and whether it's in rotation, indexed by id-1
+	health       []StreamHealth
+	numUnhealthy int
+	healthPolicy HealthPolicy
+	// Which streams have been destroyed without being replaced, and which
+	// are being recreated, indexed by id-1
+	released   []bool
+	recreating []bool
+	// Creates a stream on the device with the given ordinal to replace one
+	// that's been destroyed. Nil if the backend can't recreate streams.
+	createStream func(device int) (Stream, error)
+
+	// Whether chunks get split with the CPU, and how fast each side has been
+	hybridPolicy    HybridPolicy
+	hybridEstimates map[hybridKey]*hybridEstimate
+
+	// How many of each chunk's slots get checked, and which
+	verifyPolicy VerifyPolicy
+	verifyRng    *rand.Rand
+
+	// Whether chunks' inputs get checked before they run
+	validatePolicy ValidatePolicy
+
+	// How the pool has been used
+	stats poolStats
+}
+
+// NewStreamPool creates a pool of streams using the active backend
+// numStreams: Number of streams per device. 2 is usually fine
+// memSize: Size in bytes of the memory each stream can use. The CPU backend
+// doesn't need any.
+func NewStreamPool(numStreams int, memSize int) (*StreamPool, error) {
+	return ActiveBackend().NewStreamPool(numStreams, memSize)
+}
+
+// newStreamPool puts streams created by a backend into a pool. The backend's
+// destroyStreams is called on them when the pool is destroyed.
+// The streams can be on any number of devices.
+func newStreamPool(streams []Stream, destroyStreams func([]Stream) error) (*StreamPool, error) {
+	if len(streams) == 0 {
+		return nil, errors.New("a stream pool needs at least one stream")
+	}
+	result := StreamPool{
+		streams:        streams,
+		destroyStreams: destroyStreams,
+		closing:        make(chan struct{}),
+		drained:        make(chan struct{}),
+		checkedOut:     make([]bool, len(streams)),
+		health:         make([]StreamHealth, len(streams)),
+		released:       make([]bool, len(streams)),
+		recreating:     make([]bool, len(streams)),
+		deviceIndex:    make([]int, len(streams)),
+	}
+	indexOf := make(map[int]int)
+	for i := range result.streams {
+		result.streams[i].id = i + 1
+		result.health[i] = StreamHealth{ID: i + 1, Device: streams[i].device}
+		index, ok := indexOf[streams[i].device]
+		if !ok {
+			index = len(result.idle)
+			indexOf[streams[i].device] = index
+			result.idle = append(result.idle, nil)
+			result.busy = append(result.busy, 0)
+		}
+		result.deviceIndex[i] = index
+		result.idle[index] = append(result.idle[index], result.streams[i])
+	}
+
+	return &result, nil
+}
+
+// How long Destroy waits for checked out streams to be returned
+const defaultDestroyTimeout = 10 * time.Second
+
+// This method gets a stream from the pool, blocking until one is free
+// Use TakeStreamContext to stop waiting
+// Once the pool is closed, this returns the zero Stream, which can't be used
+// to run anything. TakeStreamContext returns ErrPoolClosed instead.
+func (sm *StreamPool) TakeStream() Stream {
+	s, _ := sm.TakeStreamContext(context.Background())
+	return s
+}
+
+// TakeStreamContext gets a stream, blocking until one is free or ctx is done.
+// If ctx is done first, it returns ctx.Err() and no stream. If the pool is
+// closed, it returns ErrPoolClosed.
+// The caller waits at the priority ctx was given with WithPriority, or at
+// PriorityPrecomputation if it wasn't given one.
+func (sm *StreamPool) TakeStreamContext(ctx context.Context) (Stream, error) {
+	return sm.TakeStreamPriority(ctx, PriorityFromContext(ctx))
+}
+
+// TakeStreamPriority is TakeStreamContext at the given priority. While every
+// stream is checked out, returned streams go to the most urgent waiting
+// caller, or to the one that's been waiting longest for callers of the same
+// priority. The pool's PriorityPolicy keeps less urgent callers from waiting
+// forever.
+// When the pool spans several devices, the stream comes from the device with
+// the fewest streams checked out.
+func (sm *StreamPool) TakeStreamPriority(ctx context.Context, priority Priority) (Stream, error) {
+	if priority < 0 || priority >= numPriorities {
+		return Stream{}, errors.Errorf("unknown stream priority %v", priority)
+	}
+	began := time.Now()
+	sm.mux.Lock()
+	if sm.closed {
+		sm.mux.Unlock()
+		return Stream{}, ErrPoolClosed
+	}
+	if !sm.hasWaiters() {
+		if s, ok := sm.takeIdle(); ok {
+			sm.recordTake(time.Since(began))
+			sm.mux.Unlock()
+			return s, nil
+		}
+	}
+	w := sm.wait(priority)
+	sm.mux.Unlock()
+
+	select {
+	case s := <-w.stream:
+		sm.mux.Lock()
+		sm.recordTake(time.Since(began))
+		sm.mux.Unlock()
+		return s, nil
+	case <-sm.closing:
+		return Stream{}, sm.stopWaiting(w, ErrPoolClosed)
+	case <-ctx.Done():
+		return Stream{}, sm.stopWaiting(w, ctx.Err())
+	}
+}
+
+// tryTakeStream gets a stream if one is idle, without waiting. It doesn't
+// take streams that other callers are waiting for.
+func (sm *StreamPool) tryTakeStream() (Stream, bool) {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	if sm.closed || sm.hasWaiters() {
+		return Stream{}, false
+	}
+	return sm.takeIdle()
+}
+
+// takeIdle checks out an idle stream from the device with the fewest streams
+// checked out, if there are any idle. mux must be held.
+func (sm *StreamPool) takeIdle() (Stream, bool) {
+	device := -1
+	for i := range sm.idle {
+		if len(sm.idle[i]) > 0 && (device == -1 || sm.busy[i] < sm.busy[device]) {
+			device = i
+		}
+	}
+	if device == -1 {
+		return Stream{}, false
+	}
+	s := sm.idle[device][0]
+	sm.idle[device] = sm.idle[device][1:]
+	sm.busy[device]++
+	sm.checkedOut[s.id-1] = true
+	sm.numCheckedOut++
+	return s, true
+}
+
+// stopWaiting takes a waiter out of line and returns err. If a stream was
+// already handed to it, the stream is given back.
+func (sm *StreamPool) stopWaiting(w *waiter, err error) error {
+	sm.mux.Lock()
+	removed := sm.removeWaiter(w)
+	sm.mux.Unlock()
+	if !removed {
+		sm.ReturnStream(<-w.stream)
+	}
+	return err
+}
+
+// ReturnStream gives a stream back to the pool. Returning a stream that isn't
+// checked out, or one that didn't come from a pool, does nothing.
+// Quarantined streams are kept out of rotation, or recreated first if the
+// pool's HealthPolicy says so.
+func (sm *StreamPool) ReturnStream(s Stream) {
+	if s.id <= 0 || s.id > len(sm.checkedOut) {
+		return
+	}
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	if !sm.checkedOut[s.id-1] || sm.recreating[s.id-1] {
+		return
+	}
+	if sm.shouldRecreate(s.id) {
+		// The stream stays checked out while it's recreated, so the pool
+		// can't be destroyed under it
+		sm.recreating[s.id-1] = true
+		sm.mux.Unlock()
+		sm.recreate(s.id)
+		sm.mux.Lock()
+		sm.recreating[s.id-1] = false
+	}
+	sm.checkIn(s.id)
+}
+
+// checkIn puts a stream that was checked out back in rotation, unless it's
+// unhealthy. mux must be held.
+func (sm *StreamPool) checkIn(id int) {
+	sm.checkedOut[id-1] = false
+	sm.numCheckedOut--
+	device := sm.deviceIndex[id-1]
+	sm.busy[device]--
+	if sm.health[id-1].State == StreamHealthy {
+		// Requeue the pool's copy, in case the caller changed theirs
+		sm.idle[device] = append(sm.idle[device], sm.streams[id-1])
+		// Nothing new gets handed out once the pool is closing
+		if !sm.closed {
+			if w := sm.nextWaiter(); w != nil {
+				next, _ := sm.takeIdle()
+				w.stream <- next
+			}
+		}
+	}
+	sm.checkDrained()
+}
+
+// checkDrained signals Destroy if the pool is closed and has all its streams
+// back. mux must be held.
+func (sm *StreamPool) checkDrained() {
+	if sm.closed && sm.numCheckedOut == 0 && !sm.isDrained {
+		sm.isDrained = true
+		close(sm.drained)
+	}
+}
+
+// Destroy all the stream pool's streams, once they've all been returned
+// The pool stops handing out streams straight away, then Destroy waits up to
+// defaultDestroyTimeout for the streams that are checked out to come back.
+// Destroying a pool a second time returns ErrPoolClosed instead of releasing
+// the streams again.
+func (sm *StreamPool) Destroy() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultDestroyTimeout)
+	defer cancel()
+	return sm.DestroyContext(ctx)
+}
+
+// DestroyContext is Destroy, but it waits for checked out streams until ctx
+// is done. If they aren't all back by then, none of the streams are
+// destroyed, because kernels could still be using them, and an error is
+// returned. The pool stays closed, and destroying it can be tried again.
+func (sm *StreamPool) DestroyContext(ctx context.Context) error {
+	sm.mux.Lock()
+	if sm.destroyed {
+		sm.mux.Unlock()
+		return ErrPoolClosed
+	}
+	if !sm.closed {
+		sm.closed = true
+		close(sm.closing)
+		sm.checkDrained()
+	}
+	sm.mux.Unlock()
+
+	select {
+	case <-sm.drained:
+	case <-ctx.Done():
+		sm.mux.Lock()
+		numCheckedOut := sm.numCheckedOut
+		sm.mux.Unlock()
+		return errors.Wrapf(ctx.Err(),
+			"%v streams are still checked out, so the pool wasn't destroyed", numCheckedOut)
+	}
+
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	// Another call could have destroyed the streams while this one waited
+	if sm.destroyed {
+		return ErrPoolClosed
+	}
+	sm.destroyed = true
+	if sm.destroyStreams == nil {
+		return nil
+	}
+	// Streams that were destroyed while being recreated are already gone
+	streams := make([]Stream, 0, len(sm.streams))
+	for i := range sm.streams {
+		if !sm.released[i] {
+			streams = append(streams, sm.streams[i])
+		}
+	}
+	return sm.destroyStreams(streams)
+}
+
+// acquire takes a stream from the pool for the length of a CPU operation on
+// numSlots slots. It returns the function that gives the stream back, which
+// also records the error the operation finished with and returns it.
+// A nil pool doesn't limit or record anything.
+func (sm *StreamPool) acquire(ctx context.Context, name string, numSlots uint32) (func(error) error, error) {
+	if sm == nil {
+		return func(err error) error { return err }, nil
+	}
+	s, err := sm.TakeStreamContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sm.recordLaunch(name, numSlots)
+	return func(err error) error {
+		if err != nil {
+			sm.mux.Lock()
+			sm.recordError(name)
+			sm.mux.Unlock()
+		}
+		sm.ReturnStream(s)
+		return err
+	}, nil
+}
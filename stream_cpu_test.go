@@ -0,0 +1,213 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"testing"
+	"time"
+)
+
+// TakeStream should block while every stream is checked out, and unblock once
+// one is returned
+func TestStreamPool_TakeBlocks(t *testing.T) {
+	streamPool, err := cpuBackend{}.NewStreamPool(2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := streamPool.TakeStream()
+	second := streamPool.TakeStream()
+	if first.id == second.id {
+		t.Errorf("the same stream %v was handed out twice", first.id)
+	}
+
+	taken := make(chan Stream)
+	go func() {
+		taken <- streamPool.TakeStream()
+	}()
+	select {
+	case <-taken:
+		t.Fatal("TakeStream should block when no streams are free")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	streamPool.ReturnStream(second)
+	select {
+	case s := <-taken:
+		if s.id != second.id {
+			t.Errorf("expected the returned stream %v, got %v", second.id, s.id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("TakeStream should unblock when a stream is returned")
+	}
+}
+
+// Returning the same stream twice, or a stream that never came from the pool,
+// shouldn't add extra streams to the pool
+func TestStreamPool_ReturnIdempotent(t *testing.T) {
+	streamPool, err := cpuBackend{}.NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := streamPool.TakeStream()
+	streamPool.ReturnStream(s)
+	streamPool.ReturnStream(s)
+	streamPool.ReturnStream(Stream{})
+
+	streamPool.TakeStream()
+	if len(streamPool.idle[0]) != 0 {
+		t.Error("the pool should only have had one stream to hand out")
+	}
+}
+
+// Destroying a pool twice should be an error, and only release the streams
+// once
+func TestStreamPool_DoubleDestroy(t *testing.T) {
+	numDestroyed := 0
+	streamPool, err := newStreamPool(make([]Stream, 2), func(streams []Stream) error {
+		numDestroyed += len(streams)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = streamPool.Destroy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = streamPool.Destroy()
+	if err != ErrPoolClosed {
+		t.Errorf("destroying the pool a second time should return ErrPoolClosed, got %v", err)
+	}
+	if numDestroyed != 2 {
+		t.Errorf("each stream should have been destroyed once, but %v were destroyed", numDestroyed)
+	}
+}
+
+// Destroy should stop handing out streams, and wait for the ones that are
+// checked out before destroying anything
+func TestStreamPool_DestroyWaits(t *testing.T) {
+	var destroyed []Stream
+	streamPool, err := newStreamPool(make([]Stream, 2), func(streams []Stream) error {
+		destroyed = streams
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := streamPool.TakeStream()
+
+	done := make(chan error)
+	go func() {
+		done <- streamPool.Destroy()
+	}()
+	select {
+	case <-done:
+		t.Fatal("Destroy shouldn't return while a stream is checked out")
+	case <-time.After(50 * time.Millisecond):
+	}
+	_, err = streamPool.TakeStreamContext(context.Background())
+	if err != ErrPoolClosed {
+		t.Errorf("taking a stream from a closing pool should return ErrPoolClosed, got %v", err)
+	}
+	if streamPool.TakeStream().id != 0 {
+		t.Error("TakeStream should return the zero stream once the pool is closed")
+	}
+
+	streamPool.ReturnStream(s)
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Destroy should return once the stream is returned")
+	}
+	if len(destroyed) != 2 {
+		t.Errorf("both streams should have been destroyed, got %v", len(destroyed))
+	}
+}
+
+// Anything waiting on a stream when the pool closes should give up
+func TestStreamPool_DestroyWakesWaiters(t *testing.T) {
+	streamPool, err := cpuBackend{}.NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := streamPool.TakeStream()
+	taken := make(chan error)
+	go func() {
+		_, err := streamPool.TakeStreamContext(context.Background())
+		taken <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	destroyErr := streamPool.DestroyContext(ctx)
+	select {
+	case err = <-taken:
+		if err != ErrPoolClosed {
+			t.Errorf("the waiter should have got ErrPoolClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("closing the pool should have woken up the waiter")
+	}
+
+	// The stream was still out, so nothing could be destroyed yet
+	if errors.Cause(destroyErr) != context.DeadlineExceeded {
+		t.Errorf("Destroy should have run out of time, got %v", destroyErr)
+	}
+	streamPool.ReturnStream(s)
+	err = streamPool.Destroy()
+	if err != nil {
+		t.Errorf("destroying the pool should succeed once the stream is back, got %v", err)
+	}
+}
+
+// A pool needs at least one stream, or TakeStream could never return
+func TestNewStreamPool_NoStreams(t *testing.T) {
+	for _, numStreams := range []int{0, -1} {
+		_, err := cpuBackend{}.NewStreamPool(numStreams, 0)
+		if err == nil {
+			t.Errorf("creating a pool with %v streams should return an error", numStreams)
+		}
+	}
+}
+
+// Chunk operations should hold a stream while they run
+func TestCpuBackend_ExpChunk_HoldsStream(t *testing.T) {
+	streamPool, err := cpuBackend{}.NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := makeTestGroup2048()
+	x := initRandomIntBuffer(g, 4, 42, 0)
+
+	s := streamPool.TakeStream()
+	done := make(chan error)
+	go func() {
+		_, err := cpuBackend{}.ExpChunk(context.Background(), streamPool, g, x, x.DeepCopy(), x.DeepCopy())
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("ExpChunk shouldn't run while the pool's only stream is checked out")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	streamPool.ReturnStream(s)
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ExpChunk should run once the stream is returned")
+	}
+}
@@ -5,100 +5,120 @@
 // LICENSE file.                                                              //
 ////////////////////////////////////////////////////////////////////////////////
 
-//+build linux,gpu
-
 package gpumaths
 
-/*
-#cgo CFLAGS: -I./cgbnBindings/powm -I/opt/xxnetwork/include
-#cgo LDFLAGS: -L/opt/xxnetwork/lib -lpowmosm75 -Wl,-rpath,./lib:/opt/xxnetwork/lib
-#include <powm_odd_export.h>
-#include <stdlib.h>
-#include <string.h>
-*/
-import "C"
 import (
+	"context"
+	"github.com/pkg/errors"
 	"gitlab.com/xx_network/crypto/large"
-	"unsafe"
 )
 
-// TODO Functions that currently take a stream as unsafe.Pointer should instead have a stream as the receiver
-type Stream struct {
-	// Pointer to stream and associated data, usable only on the C side
-	s unsafe.Pointer
-	// This byte slice contains the entire range of the CPU buffer that this stream can use
-	cpuData []byte
-	// Same data but in words!
-	cpuDataWords large.Bits
-}
-
 // Return the portion of the stream's CPU memory that's used for outputs
 // Outputs come after inputs and constants
-func (s *Stream) getCpuOutputsWords(g gpumathsEnv, kernel C.enum_kernel, numItems int) large.Bits {
+func (s *Stream) getCpuOutputsWords(g gpumathsEnv, kernel kernel, numItems int) large.Bits {
 	start := g.getConstantsSizeWords(kernel) + g.getInputSizeWords(kernel)*numItems
 	end := start + g.getOutputSizeWords(kernel)*numItems
 	return s.cpuDataWords[start:end]
 }
 
 // Inputs come after constants and before outputs
-func (s *Stream) getCpuInputsWords(g gpumathsEnv, kernel C.enum_kernel, numItems int) large.Bits {
+func (s *Stream) getCpuInputsWords(g gpumathsEnv, kernel kernel, numItems int) large.Bits {
 	start := g.getConstantsSizeWords(kernel)
 	end := start + g.getInputSizeWords(kernel)*numItems
 	return s.cpuDataWords[start:end]
 }
 
 // Constants exist at the very start of the buffer
-func (s *Stream) getCpuConstantsWords(g gpumathsEnv, kernel C.enum_kernel) large.Bits {
+func (s *Stream) getCpuConstantsWords(g gpumathsEnv, kernel kernel) large.Bits {
 	return s.cpuDataWords[:g.getConstantsSizeWords(kernel)]
 }
 
-// Optional improvements:
-//  - create streams with high priority to speed up kernels used for realtime
-type StreamPool struct {
-	// Used to prevent concurrent access to streams
-	streamChan chan Stream
-	// Used to time-bound stream deletion. These are the same streams that you can get from the channel
-	streams []Stream
+// numStreams: Number of streams per device. 2 is usually fine
+// The streams are all created on the first device
+func (b gpuBackend) NewStreamPool(numStreams int, memSize int) (*StreamPool, error) {
+	return b.NewStreamPoolOnDevices([]int{0}, numStreams, memSize)
 }
 
-// numStreams: Number of streams per device. 2 is usually fine
-func NewStreamPool(numStreams int, memSize int) (*StreamPool, error) {
+// NumDevices returns how many devices the backend can create streams on
+func (b gpuBackend) NumDevices() (int, error) {
+	err := b.dev.init()
+	if err != nil {
+		return 0, err
+	}
+	return b.dev.numDevices()
+}
+
+// NewStreamPoolOnDevices creates numStreams streams on each of the devices
+// with the given ordinals
+func (b gpuBackend) NewStreamPoolOnDevices(devices []int, numStreams int, memSize int) (*StreamPool, error) {
 	// We should be able to init CUDA here and have it work, right?
-	err := initCuda()
+	err := b.dev.init()
 	if err != nil {
 		return nil, err
 	}
-	// Each stream should support all operations if there's enough memory available
-	var result StreamPool
-	streams, err := createStreams(numStreams, memSize)
+	numDevices, err := b.dev.numDevices()
+	if err != nil {
+		return nil, err
+	}
+	err = checkDevices(devices, numDevices)
 	if err != nil {
-		// TODO Destroy streams first before returning
 		return nil, err
 	}
-	result.streams = streams
-	result.streamChan = make(chan Stream, len(streams))
-	for i := range result.streams {
-		result.streamChan <- result.streams[i]
+	// Each stream should support all operations if there's enough memory available
+	var streams []Stream
+	for _, ordinal := range devices {
+		deviceStreams, err := b.dev.createStreams(ordinal, numStreams, memSize)
+		if err != nil {
+			// Don't leak the streams that were already created on other
+			// devices
+			destroyErr := b.dev.destroyStreams(streams)
+			if destroyErr != nil {
+				return nil, errors.Wrap(destroyErr, err.Error())
+			}
+			return nil, errors.Wrapf(err, "couldn't create streams on device %v", ordinal)
+		}
+		streams = append(streams, deviceStreams...)
 	}
 
-	return &result, err
-}
-
-// If you need to, it's also possible to create an equivalent method that times out
-// This method gets a stream from the channel
-func (sm *StreamPool) TakeStream() Stream {
-	return <-sm.streamChan
+	pool, err := newStreamPool(streams, b.dev.destroyStreams)
+	if err != nil {
+		return nil, err
+	}
+	pool.createStream = func(ordinal int) (Stream, error) {
+		replacements, err := b.dev.createStreams(ordinal, 1, memSize)
+		if err != nil {
+			return Stream{}, err
+		}
+		return replacements[0], nil
+	}
+	return pool, nil
 }
 
-func (sm *StreamPool) ReturnStream(s Stream) {
-	if s.s != nil {
-		sm.streamChan <- s
+// takeGPUStream gets a stream from the pool for a kernel to run on
+// Pools created by other backends don't have device streams, so the stream is
+// given straight back and an error is returned instead
+func takeGPUStream(ctx context.Context, p *StreamPool) (Stream, error) {
+	stream, err := p.TakeStreamContext(ctx)
+	if err != nil {
+		return Stream{}, err
 	}
+	if stream.s == nil {
+		p.ReturnStream(stream)
+		return Stream{}, errors.New("stream pool wasn't created by the gpu backend")
+	}
+	return stream, nil
 }
 
-// Destroy all the stream pool's streams
-// This doesn't wait on any work to finish before destroying the streams.
-// If it's a problem in the future I'll have this method empty the channel before destroying the streams.
-func (sm *StreamPool) Destroy() error {
-	return destroyStreams(sm.streams)
+// tryTakeGPUStream gets a stream from the pool if one is free, without
+// waiting or taking one that another caller is waiting for
+func tryTakeGPUStream(p *StreamPool) (Stream, bool) {
+	stream, ok := p.tryTakeStream()
+	if !ok {
+		return Stream{}, false
+	}
+	if stream.s == nil {
+		p.ReturnStream(stream)
+		return Stream{}, false
+	}
+	return stream, true
 }
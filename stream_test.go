@@ -12,7 +12,7 @@ package gpumaths
 import "testing"
 
 func TestMaxSlots(t *testing.T) {
-	env := gpumaths4096{}
+	env := mustChooseEnv(t, cudaDevice{}, 4096)
 	// Elgamal does about twice the math, so the max number of slots should be about half of powm odd
 	// The difference comes from the number of constants needed
 	offOfHalf := (float32(env.maxSlots(88888, kernelPowmOdd)) / float32(env.maxSlots(88888, kernelElgamal))) - 2
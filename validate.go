@@ -0,0 +1,149 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"fmt"
+	"gitlab.com/elixxir/crypto/cyclic"
+	"math/big"
+	"sort"
+)
+
+// validate.go contains the optional checks of chunks' inputs. A kernel packs
+// each number into a fixed number of words, so numbers that are too wide get
+// truncated, and numbers that aren't in the group give garbage on either
+// backend. Checking a number is a comparison with the prime, which is cheap
+// next to the modular arithmetic that's done with it.
+
+// ValidatePolicy decides whether chunks' inputs are checked before they run.
+// The zero value doesn't check them.
+type ValidatePolicy struct {
+	// Check that every element is in 0 < v < p, and that no exponent has
+	// more bits than p
+	Enabled bool
+}
+
+// InvalidInputError is returned when some of a chunk's inputs are out of
+// range. None of the chunk's slots are computed.
+type InvalidInputError struct {
+	// Name of the chunk function, e.g. "ExpChunk"
+	Op string
+	// Names of the inputs that were out of range, e.g. "x", in the order the
+	// chunk function takes them
+	Inputs []string
+	// Indices within the chunk of the slots with inputs out of range, in
+	// order. Constants, like publicCypherKey, aren't in any slot.
+	Slots []uint32
+}
+
+func (e *InvalidInputError) Error() string {
+	return fmt.Sprintf("%v: inputs %v were out of range in %v slots: %v",
+		e.Op, e.Inputs, len(e.Slots), e.Slots)
+}
+
+// SetValidatePolicy sets whether chunks' inputs get checked before they run
+// on the pool's streams
+func (sm *StreamPool) SetValidatePolicy(policy ValidatePolicy) {
+	sm.mux.Lock()
+	sm.validatePolicy = policy
+	sm.mux.Unlock()
+}
+
+// GetValidatePolicy returns whether chunks' inputs get checked
+func (sm *StreamPool) GetValidatePolicy() ValidatePolicy {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	return sm.validatePolicy
+}
+
+// chunkInput is one of a chunk's inputs, for validation
+type chunkInput struct {
+	name string
+	// Each slot's value, or nil if the input is a constant
+	slots    Ints
+	constant *cyclic.Int
+	// Exponents can be zero or at least p, as long as they aren't wider than p
+	exponent bool
+}
+
+// elements is an input with a group element in each slot
+func elements(name string, slots Ints) chunkInput {
+	return chunkInput{name: name, slots: slots}
+}
+
+// exponents is an input with an exponent in each slot
+func exponents(name string, slots Ints) chunkInput {
+	return chunkInput{name: name, slots: slots, exponent: true}
+}
+
+// constElement is a group element that's the same for every slot
+func constElement(name string, v *cyclic.Int) chunkInput {
+	return chunkInput{name: name, constant: v}
+}
+
+// constExponent is an exponent that's the same for every slot
+func constExponent(name string, v *cyclic.Int) chunkInput {
+	return chunkInput{name: name, constant: v, exponent: true}
+}
+
+// validate checks a chunk's inputs if the pool's validate policy is on. The
+// pool may be nil, in which case nothing is checked.
+func (sm *StreamPool) validate(op string, g *cyclic.Group, inputs ...chunkInput) error {
+	if sm == nil || !sm.GetValidatePolicy().Enabled {
+		return nil
+	}
+	return validateInputs(op, g, inputs...)
+}
+
+// validateInputs returns an InvalidInputError listing every input and slot
+// that's out of range for the group
+func validateInputs(op string, g *cyclic.Group, inputs ...chunkInput) error {
+	var p, v big.Int
+	p.SetBits(g.GetP().Bits())
+	// SetBits shares the words instead of copying them, which is fine since
+	// they're only read
+	inRange := func(in chunkInput, x *cyclic.Int) bool {
+		if x == nil {
+			return false
+		}
+		v.SetBits(x.Bits())
+		if in.exponent {
+			return v.BitLen() <= p.BitLen()
+		}
+		return v.Sign() > 0 && v.Cmp(&p) < 0
+	}
+
+	var badInputs []string
+	badSlots := make(map[uint32]bool)
+	for _, in := range inputs {
+		bad := false
+		if in.slots == nil {
+			bad = !inRange(in, in.constant)
+		} else {
+			for i := uint32(0); i < uint32(in.slots.Len()); i++ {
+				if !inRange(in, in.slots.Get(i)) {
+					badSlots[i] = true
+					bad = true
+				}
+			}
+		}
+		if bad {
+			badInputs = append(badInputs, in.name)
+		}
+	}
+	if len(badInputs) == 0 {
+		return nil
+	}
+
+	slots := make([]uint32, 0, len(badSlots))
+	for slot := range badSlots {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+	return &InvalidInputError{Op: op, Inputs: badInputs, Slots: slots}
+}
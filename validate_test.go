@@ -0,0 +1,94 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"reflect"
+	"testing"
+)
+
+// Out of range inputs should be listed by slot, on both backends, and stop
+// any slots from being computed
+func TestValidatePolicy_ReportsSlots(t *testing.T) {
+	g := makeTestGroup2048()
+	gpu, gpuPool := newEmulatedPool(t, g, kernelPowmOdd)
+	cpuPool, err := cpuBackend{}.NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		b Backend
+		p *StreamPool
+	}{{cpuBackend{}, cpuPool}, {gpu, gpuPool}} {
+		c.p.SetValidatePolicy(ValidatePolicy{Enabled: true})
+		x := initRandomIntBuffer(g, 5, 1, 0)
+		y := initRandomIntBuffer(g, 5, 2, 32)
+		z := g.NewIntBuffer(5, g.NewInt(1))
+		// SetBytes doesn't reduce mod p, so it can make ints outside the group.
+		// Zero isn't an element of the group, but it's a fine exponent
+		g.SetBytes(x.Get(1), []byte{0})
+		g.SetBytes(y.Get(1), []byte{0})
+		// Nor is p itself
+		g.SetBytes(x.Get(3), g.GetPBytes())
+		// An exponent can't be wider than p
+		g.SetBytes(y.Get(4), append(g.GetPBytes(), 0))
+
+		_, err := c.b.ExpChunk(context.Background(), c.p, g, x, y, z)
+		var invalid *InvalidInputError
+		if !errors.As(err, &invalid) {
+			t.Fatalf("%v: expected an InvalidInputError, got %v", c.b.Name(), err)
+		}
+		expected := &InvalidInputError{Op: "ExpChunk", Inputs: []string{"x", "y"}, Slots: []uint32{1, 3, 4}}
+		if !reflect.DeepEqual(invalid, expected) {
+			t.Errorf("%v: expected %+v, got %+v", c.b.Name(), expected, invalid)
+		}
+		for i := uint32(0); i < 5; i++ {
+			if z.Get(i).Cmp(g.NewInt(1)) != 0 {
+				t.Errorf("%v: slot %v was computed even though the inputs were invalid", c.b.Name(), i)
+			}
+		}
+
+		// Without the policy, nothing is checked
+		c.p.SetValidatePolicy(ValidatePolicy{})
+		_, err = c.b.ExpChunk(context.Background(), c.p, g, x, y, z)
+		if err != nil {
+			t.Errorf("%v: inputs shouldn't be checked with the policy off, got %v", c.b.Name(), err)
+		}
+	}
+}
+
+// Constants are checked too, but aren't in any slot
+func TestValidatePolicy_Constants(t *testing.T) {
+	g := makeTestGroup2048()
+	pool, err := cpuBackend{}.NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.SetValidatePolicy(ValidatePolicy{Enabled: true})
+	x := initRandomIntBuffer(g, 3, 1, 0)
+
+	publicCypherKey := g.SetBytes(g.NewInt(1), g.GetPBytes())
+	err = cpuBackend{}.ElGamalChunk(context.Background(), pool, g, x, x, publicCypherKey,
+		x.DeepCopy(), x.DeepCopy())
+	var invalid *InvalidInputError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected an InvalidInputError, got %v", err)
+	}
+	if !reflect.DeepEqual(invalid.Inputs, []string{"publicCypherKey"}) || len(invalid.Slots) != 0 {
+		t.Errorf("only publicCypherKey should have been invalid, got %+v", invalid)
+	}
+
+	err = cpuBackend{}.Mul2Chunk(context.Background(), pool, g, x,
+		IntSlice{x.Get(0), nil, x.Get(2)}, IntSlice{g.NewInt(1), g.NewInt(1), g.NewInt(1)})
+	if !errors.As(err, &invalid) || !reflect.DeepEqual(invalid.Slots, []uint32{1}) {
+		t.Errorf("a missing int should be invalid, got %v", err)
+	}
+}
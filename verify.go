@@ -0,0 +1,138 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// verify.go contains spot checks of GPU results. A sample of each chunk's
+// slots gets recomputed with the cryptops reference implementations, to
+// catch the GPU silently computing the wrong thing.
+
+// VerifyPolicy decides how many slots of each chunk run on the GPU get
+// checked. The zero value doesn't check any.
+type VerifyPolicy struct {
+	// Number of slots to check in each chunk
+	Samples int
+	// Fraction of each chunk's slots to check, if that's more than Samples
+	Fraction float64
+	// Seeds the choice of slots, so a failure can be reproduced by running
+	// the same chunks with the same seed
+	Seed int64
+}
+
+// MismatchError is returned when slots computed on the GPU don't match the
+// reference implementation. The GPU's results are still written to the
+// outputs, so they can be inspected.
+type MismatchError struct {
+	// Name of the chunk function, e.g. "ExpChunk"
+	Op string
+	// How many slots were checked
+	NumChecked int
+	// Indices within the chunk of the slots that didn't match, in order
+	Slots []uint32
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("%v: %v of %v checked slots didn't match the reference implementation: %v",
+		e.Op, len(e.Slots), e.NumChecked, e.Slots)
+}
+
+// SetVerifyPolicy sets how many slots of each chunk get checked. The
+// sampling starts over from the policy's seed.
+func (sm *StreamPool) SetVerifyPolicy(policy VerifyPolicy) {
+	sm.mux.Lock()
+	sm.verifyPolicy = policy
+	sm.verifyRng = rand.New(rand.NewSource(policy.Seed))
+	sm.mux.Unlock()
+}
+
+// GetVerifyPolicy returns how many slots of each chunk get checked
+func (sm *StreamPool) GetVerifyPolicy() VerifyPolicy {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	return sm.verifyPolicy
+}
+
+// verifySample chooses which of numSlots slots to check, in order
+func (sm *StreamPool) verifySample(numSlots uint32) []uint32 {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	k := uint32(0)
+	if sm.verifyPolicy.Samples > 0 {
+		k = uint32(sm.verifyPolicy.Samples)
+	}
+	if sm.verifyPolicy.Fraction > 0 {
+		fromFraction := uint32(math.Ceil(sm.verifyPolicy.Fraction * float64(numSlots)))
+		if fromFraction > k {
+			k = fromFraction
+		}
+	}
+	if k == 0 || numSlots == 0 {
+		return nil
+	}
+	if k >= numSlots {
+		all := make([]uint32, numSlots)
+		for i := range all {
+			all[i] = uint32(i)
+		}
+		return all
+	}
+
+	// Floyd's algorithm picks k distinct slots without looking at all of them
+	chosen := make(map[uint32]bool, k)
+	for j := numSlots - k; j < numSlots; j++ {
+		t := uint32(sm.verifyRng.Int63n(int64(j) + 1))
+		if chosen[t] {
+			chosen[j] = true
+		} else {
+			chosen[t] = true
+		}
+	}
+	sample := make([]uint32, 0, k)
+	for slot := range chosen {
+		sample = append(sample, slot)
+	}
+	sort.Slice(sample, func(i, j int) bool { return sample[i] < sample[j] })
+	return sample
+}
+
+// slotCheck recomputes a slot whose inputs were saved and returns whether
+// the computed outputs match
+type slotCheck struct {
+	slot  uint32
+	check func() bool
+}
+
+// saveChecks saves the inputs of each sampled slot, before the chunk runs
+// and possibly overwrites them
+func saveChecks(op gpuOp, sample []uint32) []slotCheck {
+	checks := make([]slotCheck, len(sample))
+	for i, slot := range sample {
+		checks[i] = slotCheck{slot: slot, check: op.check(slot)}
+	}
+	return checks
+}
+
+// runChecks checks the sampled slots after the chunk has run
+func runChecks(op gpuOp, checks []slotCheck) error {
+	var bad []uint32
+	for _, c := range checks {
+		if !c.check() {
+			bad = append(bad, c.slot)
+		}
+	}
+	if len(bad) > 0 {
+		return &MismatchError{Op: op.name, NumChecked: len(checks), Slots: bad}
+	}
+	return nil
+}
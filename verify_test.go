@@ -0,0 +1,140 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"gitlab.com/elixxir/crypto/cyclic"
+	"reflect"
+	"testing"
+)
+
+// corruptHooks silently flip bits in the outputs of a kernel whenever corrupt
+// returns true
+func corruptHooks(t *testing.T, g *cyclic.Group, corrupt func() bool) testHooks {
+	env := mustChooseEnv(t, emulatedDevice{}, g.GetP().BitLen())
+	// What the last kernel enqueued was, so its outputs can be found
+	var k kernel
+	var numSlots int
+	return testHooks{
+		onEnqueue: func(_ Stream, whichToRun kernel, n int) {
+			k, numSlots = whichToRun, n
+		},
+		onGet: func(stream Stream) error {
+			if corrupt() {
+				outputs := stream.getCpuOutputsWords(env, k, numSlots)
+				for i := range outputs {
+					outputs[i] ^= 1
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func TestStreamPool_VerifySample(t *testing.T) {
+	pool, err := cpuBackend{}.NewStreamPool(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pool.verifySample(100) != nil {
+		t.Error("nothing should be sampled while the policy is off")
+	}
+
+	pool.SetVerifyPolicy(VerifyPolicy{Samples: 5, Seed: 42})
+	first := pool.verifySample(100)
+	if len(first) != 5 {
+		t.Fatalf("5 slots should have been sampled, got %v", first)
+	}
+	for i := range first {
+		if first[i] >= 100 || (i > 0 && first[i] <= first[i-1]) {
+			t.Fatalf("samples should be distinct slots in order, got %v", first)
+		}
+	}
+	// Resetting the policy with the same seed samples the same slots
+	pool.SetVerifyPolicy(VerifyPolicy{Samples: 5, Seed: 42})
+	if !reflect.DeepEqual(first, pool.verifySample(100)) {
+		t.Error("the same seed should sample the same slots")
+	}
+
+	// The fraction is used when it asks for more slots
+	pool.SetVerifyPolicy(VerifyPolicy{Samples: 5, Fraction: 0.2})
+	if len(pool.verifySample(100)) != 20 {
+		t.Error("a fifth of the slots should have been sampled")
+	}
+	// And there can't be more samples than slots
+	if len(pool.verifySample(3)) != 3 {
+		t.Error("every slot should have been sampled")
+	}
+}
+
+// Correct results pass verification
+func TestVerify_Pass(t *testing.T) {
+	for _, tg := range emulatedTestGroups {
+		t.Run(tg.name, func(t *testing.T) {
+			g := tg.make()
+			b, pool := newEmulatedPool(t, g, kernelElgamal)
+			pool.SetVerifyPolicy(VerifyPolicy{Fraction: 1})
+			key := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+			privateKey := initRandomIntBuffer(g, emulatedNumSlots, 2, 32)
+			publicCypherKey := initRandomIntBuffer(g, 1, 3, 0).Get(0)
+			ecrKey := initRandomIntBuffer(g, emulatedNumSlots, 4, 0)
+			cypher := initRandomIntBuffer(g, emulatedNumSlots, 5, 0)
+
+			// ElGamal overwrites its inputs, so this only passes if the
+			// inputs were saved before the kernels ran
+			err := b.ElGamalChunk(context.Background(), pool, g, key, privateKey, publicCypherKey, ecrKey, cypher)
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// Checking every slot finds every corrupted one
+func TestVerify_Mismatch(t *testing.T) {
+	g := makeTestGroup2048()
+	b, pool := newTestPool(t, g, kernelPowmOdd, 1, corruptHooks(t, g, onCalls(2)))
+	pool.SetVerifyPolicy(VerifyPolicy{Fraction: 1})
+	x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+	y := initRandomIntBuffer(g, emulatedNumSlots, 2, 32)
+	z := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+
+	_, err := b.ExpChunk(context.Background(), pool, g, x, y, z)
+	mismatch, ok := err.(*MismatchError)
+	if !ok {
+		t.Fatalf("ExpChunk should have returned a mismatch, got %v", err)
+	}
+	// The second kernel had slots 3 to 5
+	if mismatch.Op != "ExpChunk" || mismatch.NumChecked != emulatedNumSlots ||
+		!reflect.DeepEqual(mismatch.Slots, []uint32{3, 4, 5}) {
+		t.Errorf("mismatch didn't list the corrupted slots: %v", mismatch)
+	}
+}
+
+// With the same seed, a sampled check finds the same corrupted slots every time
+func TestVerify_MismatchReproducible(t *testing.T) {
+	var errs []error
+	for i := 0; i < 2; i++ {
+		g := makeTestGroup2048()
+		b, pool := newTestPool(t, g, kernelMul2, 1, corruptHooks(t, g, onCalls(1, 2, 3)))
+		pool.SetVerifyPolicy(VerifyPolicy{Samples: 3, Seed: 7})
+		x := initRandomIntBuffer(g, emulatedNumSlots, 1, 0)
+		y := initRandomIntBuffer(g, emulatedNumSlots, 2, 0)
+		result := g.NewIntBuffer(emulatedNumSlots, g.NewInt(1))
+
+		errs = append(errs, b.Mul2Chunk(context.Background(), pool, g, x, y, result))
+	}
+	mismatch, ok := errs[0].(*MismatchError)
+	if !ok || len(mismatch.Slots) != 3 {
+		t.Fatalf("every sampled slot should have mismatched, got %v", errs[0])
+	}
+	if !reflect.DeepEqual(errs[0], errs[1]) {
+		t.Errorf("the same seed gave different mismatches: %v and %v", errs[0], errs[1])
+	}
+}
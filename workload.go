@@ -0,0 +1,165 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+// workload.go contains the sizing of stream pools from the operations that
+// will run on them, so callers don't have to work out memSize themselves.
+
+// Op is one of the chunk operations, as far as a stream's buffer is
+// concerned
+type Op int
+
+const (
+	OpExp Op = iota
+	OpElGamal
+	OpReveal
+	// Mul2Chunk and Mul2Slice run the same kernel
+	OpMul2
+	OpMul3
+	numOps
+)
+
+// The kernel that each op runs
+var opKernels = [numOps]kernel{
+	OpExp:     kernelPowmOdd,
+	OpElGamal: kernelElgamal,
+	OpReveal:  kernelReveal,
+	OpMul2:    kernelMul2,
+	OpMul3:    kernelMul3,
+}
+
+func (o Op) String() string {
+	switch o {
+	case OpExp:
+		return "Exp"
+	case OpElGamal:
+		return "ElGamal"
+	case OpReveal:
+		return "Reveal"
+	case OpMul2:
+		return "Mul2"
+	case OpMul3:
+		return "Mul3"
+	default:
+		return fmt.Sprintf("Op(%d)", int(o))
+	}
+}
+
+// kernel returns the kernel that op runs, or ErrUnknownKernel if op isn't
+// one of the chunk operations
+func (o Op) kernel() (kernel, error) {
+	if o < 0 || o >= numOps {
+		return 0, errors.Wrapf(ErrUnknownKernel, "unknown op %v", o)
+	}
+	return opKernels[o], nil
+}
+
+// Workload describes what a stream pool will be used for
+type Workload struct {
+	// Length in bits of the group's prime, i.e. g.GetP().BitLen()
+	BitLen int
+	// The operations that will run on the pool
+	Ops []Op
+	// How many slots each stream should hold for every one of the Ops
+	BatchSize int
+}
+
+func (w Workload) check() error {
+	if w.BitLen <= 0 {
+		return errors.Errorf("a workload's primes need a positive bit length, not %v", w.BitLen)
+	}
+	if w.BatchSize <= 0 {
+		return errors.Errorf("a workload's batch size must be at least 1, not %v", w.BatchSize)
+	}
+	if len(w.Ops) == 0 {
+		return errors.New("a workload needs at least one op")
+	}
+	for _, op := range w.Ops {
+		_, err := op.kernel()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WorkloadBackend is implemented by backends whose streams need memory sized
+// for the operations that run on them
+type WorkloadBackend interface {
+	Backend
+	// NewStreamPoolForWorkload creates numStreams streams, each with the
+	// least memory that holds w.BatchSize slots of every op in w
+	NewStreamPoolForWorkload(numStreams int, w Workload) (*StreamPool, error)
+}
+
+// NewStreamPoolForWorkload creates a pool of streams using the active
+// backend, with just enough memory in each stream for a batch of
+// w.BatchSize slots of any of w.Ops to run without being split. Use
+// SlotCapacity to find how many slots of each op fit.
+// Backends whose streams don't have memory just get numStreams streams.
+func NewStreamPoolForWorkload(numStreams int, w Workload) (*StreamPool, error) {
+	b := ActiveBackend()
+	if wb, ok := b.(WorkloadBackend); ok {
+		return wb.NewStreamPoolForWorkload(numStreams, w)
+	}
+	err := w.check()
+	if err != nil {
+		return nil, err
+	}
+	return b.NewStreamPool(numStreams, 0)
+}
+
+// NewStreamPoolForWorkload creates numStreams streams on the first device,
+// each with the least memory that holds w.BatchSize slots of every op in w
+func (b gpuBackend) NewStreamPoolForWorkload(numStreams int, w Workload) (*StreamPool, error) {
+	err := w.check()
+	if err != nil {
+		return nil, err
+	}
+	env, err := b.dev.chooseEnv(w.BitLen)
+	if err != nil {
+		return nil, err
+	}
+	memSize := 0
+	for _, op := range w.Ops {
+		err = env.checkKernel(opKernels[op])
+		if err != nil {
+			return nil, err
+		}
+		size := env.streamSizeContaining(w.BatchSize, opKernels[op])
+		if size > memSize {
+			memSize = size
+		}
+	}
+
+	pool, err := b.NewStreamPool(numStreams, memSize)
+	if err != nil {
+		return nil, err
+	}
+	pool.slotCapacity = make(map[Op]int, len(w.Ops))
+	for _, op := range w.Ops {
+		pool.slotCapacity[op] = env.maxSlots(memSize, opKernels[op])
+	}
+	return pool, nil
+}
+
+// SlotCapacity returns how many slots of op each of the pool's streams holds
+// at once. Chunks with more slots than that are split into several kernels.
+// It returns false if the pool wasn't sized for a workload that included op,
+// or if the backend doesn't limit the number of slots.
+func (sm *StreamPool) SlotCapacity(op Op) (int, bool) {
+	capacity, ok := sm.slotCapacity[op]
+	return capacity, ok
+}
+
+var _ WorkloadBackend = gpuBackend{}
@@ -0,0 +1,118 @@
+////////////////////////////////////////////////////////////////////////////////
+// Copyright © 2022 xx foundation                                             //
+//                                                                            //
+// Use of this source code is governed by a license that can be found in the  //
+// LICENSE file.                                                              //
+////////////////////////////////////////////////////////////////////////////////
+
+package gpumaths
+
+import (
+	"context"
+	"gitlab.com/elixxir/gpumathsgo/cryptops"
+	"testing"
+)
+
+// Each stream should be just big enough for a batch of the op that needs the
+// most memory, and the other ops should fit at least as many slots
+func TestGPUBackend_NewStreamPoolForWorkload(t *testing.T) {
+	b := gpuBackend{dev: emulatedDevice{}}
+	const batchSize = 5
+	w := Workload{
+		BitLen:    2048,
+		Ops:       []Op{OpExp, OpMul2, OpElGamal},
+		BatchSize: batchSize,
+	}
+	pool, err := b.NewStreamPoolForWorkload(2, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Destroy()
+
+	env := mustChooseEnv(t, b.dev, w.BitLen)
+	memSize := len(pool.streams[0].cpuData)
+	largest := 0
+	for _, op := range w.Ops {
+		capacity, ok := pool.SlotCapacity(op)
+		if !ok {
+			t.Fatalf("the pool should know its capacity for %v", op)
+		}
+		if capacity < batchSize {
+			t.Errorf("a batch of %v should fit, but only %v slots do", op, capacity)
+		}
+		if capacity != env.maxSlots(memSize, opKernels[op]) {
+			t.Errorf("wrong capacity %v for %v", capacity, op)
+		}
+		if env.maxSlots(memSize-1, opKernels[op]) < batchSize {
+			largest++
+		}
+	}
+	if largest == 0 {
+		t.Errorf("%v bytes per stream is more than any of the ops needs", memSize)
+	}
+	if _, ok := pool.SlotCapacity(OpReveal); ok {
+		t.Error("the pool wasn't sized for reveal")
+	}
+}
+
+// A chunk the size of the batch should run in a single kernel
+func TestNewStreamPoolForWorkload_RunsBatch(t *testing.T) {
+	g := makeTestGroup2048()
+	b := gpuBackend{dev: emulatedDevice{}}
+	const batchSize = 6
+	pool, err := b.NewStreamPoolForWorkload(1, Workload{
+		BitLen:    g.GetP().BitLen(),
+		Ops:       []Op{OpMul3},
+		BatchSize: batchSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := initRandomIntBuffer(g, batchSize, 1, 0)
+	y := initRandomIntBuffer(g, batchSize, 2, 0)
+	z := initRandomIntBuffer(g, batchSize, 3, 0)
+	result := g.NewIntBuffer(batchSize, g.NewInt(1))
+	err = b.Mul3Chunk(context.Background(), pool, g, x, y, z, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := z.DeepCopy()
+	for i := uint32(0); i < batchSize; i++ {
+		cryptops.Mul3(g, x.Get(i), y.Get(i), expected.Get(i))
+	}
+	checkSlots(t, expected, result)
+}
+
+// The CPU backend doesn't limit how many slots run at once
+func TestNewStreamPoolForWorkload_CPU(t *testing.T) {
+	pool, err := NewStreamPoolForWorkload(2, Workload{BitLen: 2048, Ops: []Op{OpExp}, BatchSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.streams) != 2 {
+		t.Errorf("expected 2 streams, got %v", len(pool.streams))
+	}
+	if _, ok := pool.SlotCapacity(OpExp); ok {
+		t.Error("the CPU backend's streams shouldn't have a capacity")
+	}
+}
+
+func TestNewStreamPoolForWorkload_Invalid(t *testing.T) {
+	b := gpuBackend{dev: emulatedDevice{}}
+	for _, w := range []Workload{
+		{BitLen: 2048, Ops: []Op{OpExp}},
+		{BitLen: 2048, BatchSize: 1},
+		{BitLen: 2048, Ops: []Op{numOps}, BatchSize: 1},
+		{Ops: []Op{OpExp}, BatchSize: 1},
+	} {
+		_, err := b.NewStreamPoolForWorkload(1, w)
+		if err == nil {
+			t.Errorf("%+v should be an invalid workload", w)
+		}
+		_, err = NewStreamPoolForWorkload(1, w)
+		if err == nil {
+			t.Errorf("%+v should be invalid on every backend", w)
+		}
+	}
+}